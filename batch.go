@@ -0,0 +1,162 @@
+package transform
+
+import "time"
+
+// BatchOptions are the options for Batch. A batch is flushed as soon as any
+// of the limits is reached. Zero values are ignored.
+type BatchOptions struct {
+	// Count is the maximum number of messages in a batch.
+	Count int
+	// Size is the number of bytes at which a batch is flushed.
+	Size int
+	// Interval is the maximum time that the first message of a batch waits
+	// for the batch to be flushed.
+	Interval time.Duration
+	// Clock is the source of time for the Interval. The default is the
+	// system clock.
+	Clock Clock
+}
+
+// Batch returns a transformer that groups the messages of t into batches,
+// and emits each batch as a single message that's encoded by the encode
+// param. A partial batch is flushed once t returns an error, such as io.EOF,
+// and the error is returned on the following read.
+//
+// When an Interval is set, t is read by a background goroutine that's
+// started on the first read. Close then stops the goroutine and closes t,
+// and it may be called while another goroutine is reading, like Prefetch. Passing nil for opts results in a
+// single batch of all messages.
+func Batch(t *Transformer, opts *BatchOptions, encode func(msgs [][]byte) ([]byte, error)) *Transformer {
+	var o BatchOptions
+	if opts != nil {
+		o = *opts
+	}
+	full := func(count, size int) bool {
+		return (o.Count > 0 && count >= o.Count) || (o.Size > 0 && size >= o.Size)
+	}
+	if o.Interval <= 0 {
+		var err error
+		return NewTransformer(func() ([]byte, error) {
+			var batch [][]byte
+			var size int
+			for err == nil && !full(len(batch), size) {
+				var msg []byte
+				msg, err = t.ReadMessage()
+				if len(msg) > 0 {
+					batch = append(batch, append([]byte(nil), msg...))
+					size += len(msg)
+				}
+			}
+			if len(batch) > 0 {
+				return encode(batch)
+			}
+			return nil, err
+		}).Upstream(t)
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	return batcher(t, full, encode, o.Clock, o.Interval, false)
+}
+
+// WindowOptions are the options for Window.
+type WindowOptions struct {
+	// Clock is the source of time for the windows. The default is the system
+	// clock.
+	Clock Clock
+}
+
+// Window returns a transformer that groups the messages of t into
+// consecutive windows of time d, and emits each window as a single message
+// that's encoded by the encode param. Empty windows are skipped. The first
+// window starts with the first read.
+//
+// The t is read by a background goroutine that's started on the first read
+// and stopped by Close, like Batch with an Interval. Passing nil for opts
+// uses the default options.
+func Window(t *Transformer, d time.Duration, opts *WindowOptions, encode func(msgs [][]byte) ([]byte, error)) *Transformer {
+	var o WindowOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	full := func(count, size int) bool { return false }
+	return batcher(t, full, encode, o.Clock, d, true)
+}
+
+// batcher groups the messages of t, which are read by a background
+// goroutine, until the batch is full or the timer fires. When window is set
+// the timer fires every d, otherwise it fires d after the first message of
+// each batch.
+func batcher(t *Transformer, full func(count, size int) bool,
+	encode func(msgs [][]byte) ([]byte, error), clock Clock, d time.Duration,
+	window bool,
+) *Transformer {
+	// unbuffered, a message is part of a batch as soon as it's been read.
+	ch := make(chan message)
+	p := newPumps()
+	var batch [][]byte
+	var size int
+	var err error
+	var tc <-chan time.Time // fires when the batch or window ends
+	var next time.Time      // end of the current window
+	flush := func() ([]byte, error) {
+		if !window {
+			tc = nil
+		}
+		msgs := batch
+		batch, size = nil, 0
+		return encode(msgs)
+	}
+	return NewTransformer(func() ([]byte, error) {
+		p.start(func() {
+			p.pump(t, ch)
+			if window {
+				next = clock.Now().Add(d)
+				tc = clock.After(d)
+			}
+		})
+		for err == nil {
+			select {
+			case msg := <-ch:
+				if len(msg.data) > 0 {
+					batch = append(batch, msg.data)
+					size += len(msg.data)
+					if len(batch) == 1 && !window {
+						tc = clock.After(d)
+					}
+				}
+				if msg.err != nil {
+					err = msg.err
+				} else if full(len(batch), size) {
+					return flush()
+				}
+			case <-tc:
+				if window {
+					// skip the windows that were missed, like a ticker.
+					now := clock.Now()
+					for !next.After(now) {
+						next = next.Add(d)
+					}
+					tc = clock.After(next.Sub(now))
+				} else {
+					tc = nil
+				}
+				if len(batch) > 0 {
+					return flush()
+				}
+			case <-p.done:
+				err = ErrClosed
+			}
+		}
+		p.halt()
+		if len(batch) > 0 {
+			return flush()
+		}
+		return nil, err
+	}).sources(t).OnClose(func() error {
+		return p.stop(t.Close, t)
+	})
+}
@@ -0,0 +1,200 @@
+package transform
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func joinBatch(msgs [][]byte) ([]byte, error) {
+	return append(bytes.Join(msgs, []byte(",")), '\n'), nil
+}
+
+func TestBatch(t *testing.T) {
+	// count
+	out, err := ioutil.ReadAll(Batch(counterMessages(7), &BatchOptions{Count: 3}, joinBatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "1,,2,,3,\n4,,5,,6,\n7,\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	// size
+	out, err = ioutil.ReadAll(Batch(counterMessages(5), &BatchOptions{Size: 4}, joinBatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "1,,2,\n3,,4,\n5,\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	// everything
+	out, err = ioutil.ReadAll(Batch(counterMessages(3), nil, joinBatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "1,,2,,3,\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+// chanMessages returns a transformer that reads messages from a channel
+// until it's closed.
+func chanMessages(ch chan string) *Transformer {
+	return NewTransformer(func() ([]byte, error) {
+		msg, ok := <-ch
+		if !ok {
+			return nil, io.EOF
+		}
+		return []byte(msg), nil
+	})
+}
+
+// manualClock only advances when told to.
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []manualTimer
+	waiting chan struct{} // receives a value for each call to After
+}
+
+type manualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1000, 0), waiting: make(chan struct{}, 64)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+	} else {
+		c.timers = append(c.timers, manualTimer{c.now.Add(d), ch})
+	}
+	c.waiting <- struct{}{}
+	return ch
+}
+
+// Advance moves the time forward by d, and fires the timers that are due.
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, tm := range c.timers {
+		if tm.at.After(c.now) {
+			timers = append(timers, tm)
+		} else {
+			tm.ch <- c.now
+		}
+	}
+	c.timers = timers
+}
+
+// readMessageAsync reads a message in the background.
+func readMessageAsync(r *Transformer) chan message {
+	res := make(chan message, 1)
+	go func() {
+		msg, err := r.ReadMessage()
+		res <- message{append([]byte(nil), msg...), err}
+	}()
+	return res
+}
+
+func TestBatchInterval(t *testing.T) {
+	ch := make(chan string)
+	clock := newManualClock()
+	r := Batch(chanMessages(ch), &BatchOptions{
+		Count: 3, Interval: time.Millisecond * 20, Clock: clock,
+	}, joinBatch)
+	defer r.Close()
+	go func() {
+		ch <- "a"
+		ch <- "b"
+		ch <- "c"
+		ch <- "d"
+	}()
+	msg, err := r.ReadMessage()
+	if err != nil || string(msg) != "a,b,c\n" {
+		t.Fatalf("expected '%v', got '%v' '%v'\n", "a,b,c\n", string(msg), err)
+	}
+	<-clock.waiting // the timer of the first batch
+	// the partial batch is flushed by the timer.
+	res := readMessageAsync(r)
+	<-clock.waiting
+	clock.Advance(time.Millisecond * 19)
+	select {
+	case msg := <-res:
+		t.Fatalf("unexpected flush '%v' '%v'", string(msg.data), msg.err)
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	if msg := <-res; msg.err != nil || string(msg.data) != "d\n" {
+		t.Fatalf("expected '%v', got '%v' '%v'\n", "d\n", string(msg.data), msg.err)
+	}
+	go func() {
+		ch <- "e"
+		close(ch)
+	}()
+	msg, err = r.ReadMessage()
+	if err != nil || string(msg) != "e\n" {
+		t.Fatalf("expected '%v', got '%v' '%v'\n", "e\n", string(msg), err)
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Fatalf("expected '%v', got '%v'\n", io.EOF, err)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	ch := make(chan string)
+	reading := make(chan struct{}, 64) // receives a value for each read
+	src := NewTransformer(func() ([]byte, error) {
+		reading <- struct{}{}
+		msg, ok := <-ch
+		if !ok {
+			return nil, io.EOF
+		}
+		return []byte(msg), nil
+	})
+	clock := newManualClock()
+	r := Window(src, time.Millisecond*30, &WindowOptions{Clock: clock}, joinBatch)
+	defer r.Close()
+	res := readMessageAsync(r)
+	<-clock.waiting
+	<-reading
+	ch <- "a"
+	<-reading
+	ch <- "b"
+	// b was taken by the batcher once the next message is being read.
+	<-reading
+	clock.Advance(time.Millisecond * 30)
+	if msg := <-res; msg.err != nil || string(msg.data) != "a,b\n" {
+		t.Fatalf("expected '%v', got '%v' '%v'\n", "a,b\n", string(msg.data), msg.err)
+	}
+	// empty windows are skipped.
+	<-clock.waiting
+	res = readMessageAsync(r)
+	clock.Advance(time.Millisecond * 60)
+	<-clock.waiting
+	ch <- "c"
+	close(ch)
+	if msg := <-res; msg.err != nil || string(msg.data) != "c\n" {
+		t.Fatalf("expected '%v', got '%v' '%v'\n", "c\n", string(msg.data), msg.err)
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Fatalf("expected '%v', got '%v'\n", io.EOF, err)
+	}
+}
@@ -0,0 +1,98 @@
+package transform
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNoCheckpoint is returned by Checkpoint when the transformer doesn't
+// support checkpoints.
+var ErrNoCheckpoint = errors.New("transform: checkpoints not supported")
+
+// Checkpoint is a position in a transformed stream, from which the
+// transformation can be resumed using Resume.
+type Checkpoint struct {
+	Offset int64  // input offset of the next message
+	Index  int64  // number of messages that were consumed
+	Skip   int    // bytes of the next message that were consumed by Read
+	State  []byte // stage specific state, if any
+}
+
+// OnCheckpoint registers a function that reports the current position of a
+// stage. The function returns the offset, relative to the start of the
+// stage's input, of the first byte that the next message is transformed
+// from, along with any state that's needed to resume. The Index and Skip
+// fields are maintained by the transformer. Returns the transformer.
+func (r *Transformer) OnCheckpoint(fn func() Checkpoint) *Transformer {
+	r.cpfn = fn
+	return r
+}
+
+// Checkpoint returns the position of the transformer. All data up to the
+// checkpoint has been consumed by the caller, including any part of a
+// message that was consumed by Read.
+//
+// Checkpoints are not supported while messages that were pushed back by
+// UnreadMessage, rather than PeekMessage, are waiting.
+//
+// The checkpoint refers to the input of this stage only. For it to be of use
+// the input must be seekable, such as a file.
+func (r *Transformer) Checkpoint() (Checkpoint, error) {
+	if r.cpfn == nil {
+		return Checkpoint{}, ErrNoCheckpoint
+	}
+	if len(r.unread) > 0 {
+		if r.peeked {
+			return r.peekcp, nil
+		}
+		// the position of arbitrary unread messages is unknown.
+		return Checkpoint{}, ErrNoCheckpoint
+	}
+	if pending := len(r.buf) - r.idx; pending > 0 {
+		// part of the last message is still waiting in the read buffer.
+		cp := r.cp
+		cp.Skip += r.cplen - pending
+		return cp, nil
+	}
+	return r.stageCheckpoint(), nil
+}
+
+func (r *Transformer) stageCheckpoint() Checkpoint {
+	cp := r.cpfn()
+	cp.Offset += r.base.Offset
+	cp.Index = r.base.Index + r.count
+	return cp
+}
+
+// advance is called for each message that's read.
+func (r *Transformer) advance(msg []byte) []byte {
+	r.count++
+	r.cplen = len(msg)
+	if r.base.Skip > 0 {
+		// the start of the message was consumed prior to resuming.
+		skip := r.base.Skip
+		if skip > len(msg) {
+			skip = len(msg)
+		}
+		r.cp.Skip = skip
+		r.cplen -= skip
+		msg = msg[skip:]
+		r.base.Skip = 0
+	} else {
+		r.cp.Skip = 0
+	}
+	return msg
+}
+
+// Resume resumes a transformation from a checkpoint. The rs param is the
+// input of the stage that returned the checkpoint, and it's positioned at
+// the checkpoint offset prior to calling fn. The fn param is the stage
+// constructor, which should restore any state from the checkpoint.
+func Resume(rs io.ReadSeeker, cp Checkpoint, fn func(r io.Reader, cp Checkpoint) *Transformer) (*Transformer, error) {
+	if _, err := rs.Seek(cp.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := fn(rs, cp)
+	r.base = cp
+	return r, nil
+}
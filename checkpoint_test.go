@@ -0,0 +1,57 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// upperLines converts lines to upper case and supports checkpoints.
+func upperLines(r io.Reader, cp Checkpoint) *Transformer {
+	br := bufio.NewReader(r)
+	var offset int64
+	return NewTransformer(func() ([]byte, error) {
+		line, err := br.ReadBytes('\n')
+		offset += int64(len(line))
+		return bytes.ToUpper(line), err
+	}).OnCheckpoint(func() Checkpoint {
+		return Checkpoint{Offset: offset}
+	})
+}
+
+func TestCheckpoint(t *testing.T) {
+	input := "lacy timber\nhybrid gossiping\ncoy radioactivity\nrocky arrow\n"
+	expect := strings.ToUpper(input)
+	if _, err := NewTransformer(nil).Checkpoint(); err != ErrNoCheckpoint {
+		t.Fatalf("expected '%v', got '%v'\n", ErrNoCheckpoint, err)
+	}
+	for n := 0; n <= len(input); n++ {
+		// read n bytes, checkpoint, and resume from there.
+		r := upperLines(strings.NewReader(input), Checkpoint{})
+		head := make([]byte, n)
+		if _, err := io.ReadFull(r, head); err != nil {
+			t.Fatal(err)
+		}
+		cp, err := r.Checkpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = Resume(strings.NewReader(input), cp, upperLines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tail, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(head)+string(tail) != expect {
+			t.Fatalf("%d: expected '%v', got '%v'", n, expect, string(head)+string(tail))
+		}
+		if cp, _ := r.Checkpoint(); cp.Offset != int64(len(input)) || cp.Index != 4 {
+			t.Fatalf("%d: unexpected checkpoint %+v", n, cp)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package transform
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// message is a message along with the error that was returned with it.
+type message struct {
+	data []byte
+	err  error
+}
+
+// pumps runs the background goroutines of a stage, which read from its
+// sources. A source may not allow for closing while it's being read, thus
+// the sources are closed once the goroutines have exited.
+type pumps struct {
+	startOnce sync.Once
+	haltOnce  sync.Once
+	done      chan struct{} // closed by halt
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	running int          // number of goroutines that haven't exited
+	closefn func() error // closes the sources once the goroutines exit
+}
+
+func newPumps() *pumps {
+	return &pumps{done: make(chan struct{})}
+}
+
+// start calls fn, which starts the goroutines, unless it was already called
+// or the goroutines were stopped.
+func (p *pumps) start(fn func()) {
+	p.startOnce.Do(fn)
+}
+
+// goroutine runs fn in a goroutine.
+func (p *pumps) goroutine(fn func()) {
+	p.mu.Lock()
+	p.running++
+	p.mu.Unlock()
+	p.wg.Add(1)
+	go func() {
+		defer p.exit()
+		fn()
+	}()
+}
+
+// exit is called by each goroutine as it exits. The last one closes the
+// sources when that was left to it by stop.
+func (p *pumps) exit() {
+	p.mu.Lock()
+	p.running--
+	var closefn func() error
+	if p.running == 0 {
+		closefn, p.closefn = p.closefn, nil
+	}
+	p.mu.Unlock()
+	p.wg.Done()
+	if closefn != nil {
+		closefn()
+	}
+}
+
+// pump reads messages from m in a goroutine and sends them to ch until an
+// error is returned or the goroutines are halted. Each message is copied
+// because the source may repurpose its own message space.
+func (p *pumps) pump(m *Transformer, ch chan<- message) {
+	p.goroutine(func() {
+		for {
+			data, err := m.ReadMessage()
+			select {
+			case ch <- message{append([]byte(nil), data...), err}:
+			case <-p.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// halt tells the goroutines to stop, without waiting for them.
+func (p *pumps) halt() {
+	p.haltOnce.Do(func() { close(p.done) })
+}
+
+// stop halts the goroutines, or prevents them from starting, and then closes
+// the sources by calling closefn. The reads that are in progress are first
+// interrupted, which requires the furthest upstream readers of srcs to
+// support read deadlines, such as a net.Conn. Otherwise, closing is left to
+// the last goroutine once its read returns, and stop returns without
+// waiting, in which case the error from closefn is discarded.
+func (p *pumps) stop(closefn func() error, srcs ...io.Reader) error {
+	p.halt()
+	p.start(func() {})
+	if !p.interrupt(srcs) {
+		p.mu.Lock()
+		if p.running > 0 {
+			p.closefn = closefn
+			p.mu.Unlock()
+			return nil
+		}
+		p.mu.Unlock()
+	}
+	p.wg.Wait()
+	return closefn()
+}
+
+// interrupt interrupts the reads of srcs while any goroutine is running.
+// Reports false when a read can't be interrupted.
+func (p *pumps) interrupt(srcs []io.Reader) bool {
+	p.mu.Lock()
+	running := p.running
+	p.mu.Unlock()
+	ok := true
+	if running > 0 {
+		for _, src := range srcs {
+			ok = interrupt(src) && ok
+		}
+	}
+	return ok
+}
+
+// closeAll returns a close function for a set of sources.
+func closeAll(ms []*Transformer) func() error {
+	return func() error {
+		var errs CloseError
+		for _, m := range ms {
+			errs = errs.append(m.Close())
+		}
+		if len(errs) == 1 {
+			return errs[0]
+		}
+		if len(errs) > 1 {
+			return errs
+		}
+		return nil
+	}
+}
+
+func messagesAll(srcs []io.Reader) []*Transformer {
+	ms := make([]*Transformer, len(srcs))
+	for i, src := range srcs {
+		ms[i] = messages(src)
+	}
+	return ms
+}
+
+// Concat returns a transformer that reads all messages from each source in
+// turn, like io.MultiReader, but with message boundaries preserved when a
+// source is a transformer. BindContext reaches all sources, and Close
+// closes them.
+func Concat(srcs ...io.Reader) *Transformer {
+	ms := messagesAll(srcs)
+	var i int
+	return NewTransformer(func() ([]byte, error) {
+		for i < len(ms) {
+			msg, err := ms[i].ReadMessage()
+			if err == io.EOF {
+				i++
+				err = nil
+				if len(msg) == 0 {
+					continue
+				}
+			}
+			return msg, err
+		}
+		return nil, io.EOF
+	}).sources(srcs...).OnClose(closeAll(ms))
+}
+
+// Merge returns a transformer that interleaves the messages from all sources
+// as they become available. Each source is read by its own goroutine, which
+// is started on the first read. The first error, other than io.EOF, stops
+// the merge. BindContext reaches all sources, and a bound context cancels
+// any read that's waiting on them.
+//
+// Close stops the goroutines and closes all sources, and it may be called
+// while another goroutine is reading. The reads that are in progress are
+// interrupted, or delay closing, like Prefetch.
+func Merge(srcs ...io.Reader) *Transformer {
+	ms := messagesAll(srcs)
+	ch := make(chan message, len(ms))
+	p := newPumps()
+	remaining := len(ms)
+	var err error
+	return NewTransformerContext(context.Background(), func(ctx context.Context) ([]byte, error) {
+		p.start(func() {
+			for _, m := range ms {
+				p.pump(m, ch)
+			}
+		})
+		for err == nil && remaining > 0 {
+			var msg message
+			select {
+			case msg = <-ch:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-p.done:
+				return nil, ErrClosed
+			}
+			switch msg.err {
+			case nil:
+				return msg.data, nil
+			case io.EOF:
+				remaining--
+				if len(msg.data) > 0 {
+					return msg.data, nil
+				}
+			default:
+				err = msg.err
+				p.halt()
+				return msg.data, err
+			}
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		return nil, err
+	}).sources(srcs...).OnClose(func() error {
+		return p.stop(closeAll(ms), srcs...)
+	})
+}
+
+// MergeSorted returns a transformer that merges sources whose messages are
+// already sorted, such as timestamped log lines, into a single sorted stream.
+// The less param reports whether message a sorts before message b.
+// BindContext reaches all sources, and Close closes them.
+func MergeSorted(less func(a, b []byte) bool, srcs ...io.Reader) *Transformer {
+	ms := messagesAll(srcs)
+	heads := make([][]byte, len(ms)) // next message of each source
+	eofs := make([]bool, len(ms))    // source is exhausted
+	// fill reads the next message of a source into its head.
+	fill := func(i int) error {
+		for heads[i] == nil && !eofs[i] {
+			msg, err := ms[i].ReadMessage()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if len(msg) > 0 {
+				heads[i] = append([]byte(nil), msg...)
+			}
+			eofs[i] = err == io.EOF
+		}
+		return nil
+	}
+	var err error
+	return NewTransformer(func() ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		min := -1
+		for i := range ms {
+			if err = fill(i); err != nil {
+				return nil, err
+			}
+			if heads[i] != nil && (min == -1 || less(heads[i], heads[min])) {
+				min = i
+			}
+		}
+		if min == -1 {
+			err = io.EOF
+			return nil, err
+		}
+		msg := heads[min]
+		heads[min] = nil
+		return msg, nil
+	}).sources(srcs...).OnClose(closeAll(ms))
+}
@@ -0,0 +1,151 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lineMessages(s string) *Transformer {
+	br := bufio.NewReader(strings.NewReader(s))
+	return NewTransformer(func() ([]byte, error) {
+		return br.ReadBytes('\n')
+	})
+}
+
+func readMessages(t *testing.T, r *Transformer) []string {
+	var msgs []string
+	for {
+		msg, err := r.ReadMessage()
+		if len(msg) > 0 {
+			msgs = append(msgs, string(msg))
+		}
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcat(t *testing.T) {
+	r := Concat(lineMessages("a\nb\n"), bytes.NewBufferString("raw"), lineMessages("c\nd"))
+	msgs := readMessages(t, r)
+	if strings.Join(msgs, "|") != "a\n|b\n|raw|c\n|d" {
+		t.Fatalf("unexpected messages %q", msgs)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r := Merge(lineMessages("a\nb\nc\n"), lineMessages("d\ne\n"), lineMessages(""))
+	defer r.Close()
+	msgs := readMessages(t, r)
+	sort.Strings(msgs)
+	if strings.Join(msgs, "") != "a\nb\nc\nd\ne\n" {
+		t.Fatalf("unexpected messages %q", msgs)
+	}
+	// errors
+	errBad := errors.New("bad")
+	r = Merge(lineMessages("a\nb\nc\n"), NewTransformer(func() ([]byte, error) {
+		return nil, errBad
+	}))
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != errBad {
+		t.Fatalf("expected '%v', got '%v'\n", errBad, err)
+	}
+}
+
+func TestMergeCloseWhileReading(t *testing.T) {
+	release := make(chan struct{})
+	blocked := NewTransformer(func() ([]byte, error) {
+		<-release
+		return nil, io.EOF
+	})
+	r := Merge(blocked)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.ReadMessage()
+		errc <- err
+	}()
+	closed := make(chan error, 1)
+	go func() { closed <- r.Close() }()
+	select {
+	case err := <-errc:
+		if err != ErrClosed {
+			t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("read was not unblocked by close")
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeCancel(t *testing.T) {
+	ch := make(chan string)
+	defer close(ch)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := Merge(chanMessages(ch)).BindContext(ctx)
+	defer r.Close()
+	go func() {
+		ch <- "a"
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+	}()
+	out, err := ioutil.ReadAll(r)
+	if err != context.Canceled || string(out) != "a" {
+		t.Fatalf("unexpected result '%s', '%v'", out, err)
+	}
+}
+
+func TestMergeBindContext(t *testing.T) {
+	less := func(a, b []byte) bool { return false }
+	stages := map[string]func(srcs ...io.Reader) *Transformer{
+		"concat": Concat,
+		"merge":  Merge,
+		"sorted": func(srcs ...io.Reader) *Transformer {
+			return MergeSorted(less, srcs...)
+		},
+	}
+	for name, stage := range stages {
+		a, b := lineMessages("a\n"), lineMessages("b\n")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		stage(a, b).BindContext(ctx)
+		for _, src := range []*Transformer{a, b} {
+			if _, err := src.ReadMessage(); err != context.Canceled {
+				t.Fatalf("%s: expected '%v', got '%v'\n", name, context.Canceled, err)
+			}
+		}
+	}
+}
+
+func TestMergeSorted(t *testing.T) {
+	less := func(a, b []byte) bool {
+		return bytes.Compare(a, b) < 0
+	}
+	r := MergeSorted(less,
+		lineMessages("01 a\n04 d\n05 e\n"),
+		lineMessages("02 b\n06 f\n"),
+		lineMessages(""),
+		lineMessages("03 c\n07 g\n"),
+	)
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "01 a\n02 b\n03 c\n04 d\n05 e\n06 f\n07 g\n"
+	if string(out) != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, string(out))
+	}
+}
@@ -0,0 +1,71 @@
+package transform
+
+import "sync"
+
+// BufferPool is a sync.Pool-backed allocator for message buffers. The zero
+// value is ready to use.
+type BufferPool struct {
+	p sync.Pool
+}
+
+func (p *BufferPool) get(n int) *[]byte {
+	if b, ok := p.p.Get().(*[]byte); ok && cap(*b) >= n {
+		*b = (*b)[:n]
+		return b
+	}
+	b := make([]byte, n)
+	return &b
+}
+
+// Message is a transformed message that's owned by the caller.
+type Message struct {
+	Data []byte // the message data
+
+	buf  *[]byte     // pooled buffer backing Data
+	pool *BufferPool // pool that buf belongs to
+}
+
+// Release returns the message buffer to its pool. The message data must not
+// be used after calling Release.
+func (m *Message) Release() {
+	if m.pool != nil && m.buf != nil {
+		m.pool.p.Put(m.buf)
+	}
+	m.Data, m.buf, m.pool = nil, nil, nil
+}
+
+// UsePool sets the pool that NextMessage allocates message buffers from.
+// Like Upstream and OnClose it's a method rather than an option of
+// NewTransformer, so that it also applies to transformers that are created
+// by constructors, such as the ones in transutil. Returns the transformer.
+func (r *Transformer) UsePool(p *BufferPool) *Transformer {
+	r.pool = p
+	return r
+}
+
+// ReadMessageCopy is like ReadMessage but returns a copy of the message,
+// which is owned by the caller and remains valid after following reads.
+func (r *Transformer) ReadMessageCopy() ([]byte, error) {
+	msg, err := r.ReadMessage()
+	if msg != nil {
+		msg = append([]byte(nil), msg...)
+	}
+	return msg, err
+}
+
+// NextMessage is like ReadMessageCopy but returns a Message, which is
+// allocated from the pool set with UsePool, if any. The caller should call
+// Release once it's done with the message. Returns a nil message when an
+// error is returned without any data.
+func (r *Transformer) NextMessage() (*Message, error) {
+	msg, err := r.ReadMessage()
+	if msg == nil && err != nil {
+		return nil, err
+	}
+	if r.pool == nil {
+		return &Message{Data: append([]byte(nil), msg...)}, err
+	}
+	buf := r.pool.get(len(msg))
+	copy(*buf, msg)
+	return &Message{Data: *buf, buf: buf, pool: r.pool}, err
+}
@@ -0,0 +1,89 @@
+package transform
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// randomMessages returns the expected messages along with a transformer
+// that emits them from a single reused buffer.
+func randomMessages(n int) ([][]byte, *Transformer) {
+	expect := make([][]byte, n)
+	for i := range expect {
+		expect[i] = make([]byte, rand.Intn(512)+1)
+		rand.Read(expect[i])
+	}
+	var buf []byte
+	var i int
+	return expect, NewTransformer(func() ([]byte, error) {
+		if i == len(expect) {
+			return nil, io.EOF
+		}
+		buf = append(buf[:0], expect[i]...)
+		i++
+		return buf, nil
+	})
+}
+
+func TestNextMessage(t *testing.T) {
+	var pool BufferPool
+	expect, r := randomMessages(1000)
+	r.UsePool(&pool)
+	msgs := make(chan *Message, 8)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		// the consumer checks each message while the producer keeps
+		// reading. the race detector reports any shared buffer.
+		defer wg.Done()
+		var i int
+		for msg := range msgs {
+			if !bytes.Equal(msg.Data, expect[i]) {
+				t.Errorf("message %d was mutated", i)
+			}
+			msg.Release()
+			i++
+		}
+	}()
+	for {
+		msg, err := r.NextMessage()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		msgs <- msg
+	}
+	close(msgs)
+	wg.Wait()
+}
+
+func TestReadMessageCopy(t *testing.T) {
+	expect, r := randomMessages(100)
+	var msgs [][]byte
+	var wg sync.WaitGroup
+	for {
+		msg, err := r.ReadMessageCopy()
+		if err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+		wg.Add(1)
+		go func(msg []byte, expect []byte) {
+			defer wg.Done()
+			if !bytes.Equal(msg, expect) {
+				t.Error("message was mutated")
+			}
+		}(msg, expect[len(msgs)-1])
+	}
+	wg.Wait()
+	for i := range msgs {
+		if !bytes.Equal(msgs[i], expect[i]) {
+			t.Fatalf("message %d was mutated", i)
+		}
+	}
+}
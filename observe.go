@@ -0,0 +1,103 @@
+package transform
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Observer receives instrumentation events from a transformer.
+type Observer interface {
+	// OnMessage is called after each transformed message, where n is the
+	// size of the message and dur is the time spent in the transform
+	// function.
+	OnMessage(n int, dur time.Duration)
+	// OnError is called when the transform function fails with an error
+	// other than io.EOF.
+	OnError(err error)
+}
+
+// BufferObserver is an Observer that also wants to know the number of bytes
+// that are waiting in the read buffer. OnBuffer is called each time a
+// message is added to the buffer by Read.
+type BufferObserver interface {
+	Observer
+	OnBuffer(n int)
+}
+
+// Observe registers an observer for the transformer. Returns the
+// transformer.
+func (r *Transformer) Observe(o Observer) *Transformer {
+	r.obs = o
+	return r
+}
+
+// Stats is a snapshot of the values collected by a Counter.
+type Stats struct {
+	Messages    int64         // number of messages
+	Errors      int64         // number of errors
+	BytesIn     int64         // bytes read through the Counter's Reader
+	BytesOut    int64         // bytes emitted by the transformer
+	Elapsed     time.Duration // time spent in the transform function
+	MaxBuffered int64         // largest number of bytes in the read buffer
+}
+
+// Counter is a ready-made Observer that collects throughput and latency
+// statistics. It's safe to call Stats while the transformer is in use.
+//
+//	var c transform.Counter
+//	r := transutil.Gunzipper(c.Reader(conn)).Observe(&c)
+type Counter struct {
+	messages    int64
+	errors      int64
+	bytesIn     int64
+	bytesOut    int64
+	elapsed     int64
+	maxBuffered int64
+}
+
+// OnMessage conforms to Observer.
+func (c *Counter) OnMessage(n int, dur time.Duration) {
+	atomic.AddInt64(&c.messages, 1)
+	atomic.AddInt64(&c.bytesOut, int64(n))
+	atomic.AddInt64(&c.elapsed, int64(dur))
+}
+
+// OnError conforms to Observer.
+func (c *Counter) OnError(err error) {
+	atomic.AddInt64(&c.errors, 1)
+}
+
+// OnBuffer conforms to BufferObserver.
+func (c *Counter) OnBuffer(n int) {
+	for {
+		max := atomic.LoadInt64(&c.maxBuffered)
+		if int64(n) <= max || atomic.CompareAndSwapInt64(&c.maxBuffered, max, int64(n)) {
+			return
+		}
+	}
+}
+
+// Reader returns a reader that counts the bytes read from r as the input of
+// the observed transformer. Message boundaries are preserved when r is a
+// transformer.
+func (c *Counter) Reader(r io.Reader) io.Reader {
+	m := messages(r)
+	return NewTransformer(func() ([]byte, error) {
+		msg, err := m.ReadMessage()
+		atomic.AddInt64(&c.bytesIn, int64(len(msg)))
+		return msg, err
+	}).Upstream(m)
+}
+
+// Stats returns a snapshot of the collected statistics.
+func (c *Counter) Stats() Stats {
+	return Stats{
+		Messages:    atomic.LoadInt64(&c.messages),
+		Errors:      atomic.LoadInt64(&c.errors),
+		BytesIn:     atomic.LoadInt64(&c.bytesIn),
+		BytesOut:    atomic.LoadInt64(&c.bytesOut),
+		Elapsed:     time.Duration(atomic.LoadInt64(&c.elapsed)),
+		MaxBuffered: atomic.LoadInt64(&c.maxBuffered),
+	}
+}
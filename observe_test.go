@@ -0,0 +1,34 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	msgs := []string{"hello", "", "world!"}
+	errBad := errors.New("bad")
+	src := c.Reader(bytes.NewBufferString("input"))
+	r := NewTransformer(func() ([]byte, error) {
+		if len(msgs) == 0 {
+			return nil, errBad
+		}
+		msg := msgs[0]
+		msgs = msgs[1:]
+		return []byte(msg), nil
+	}).Upstream(src).Observe(&c)
+	if _, err := ioutil.ReadAll(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != errBad {
+		t.Fatalf("expected '%v', got '%v'\n", errBad, err)
+	}
+	stats := c.Stats()
+	if stats.Messages != 3 || stats.Errors != 1 || stats.BytesIn != 5 ||
+		stats.BytesOut != 11 || stats.MaxBuffered != 6 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,130 @@
+package transform
+
+import (
+	"context"
+	"sync"
+)
+
+type parallelResult struct {
+	data []byte
+	err  error
+}
+
+type parallelJob struct {
+	data []byte
+	res  chan parallelResult
+}
+
+// Parallel returns a transformer that fans messages out to n worker
+// goroutines and emits the results in the original order.
+//
+// The split param is called sequentially and returns the next input message,
+// or an error such as io.EOF once the input is exhausted. The work param
+// performs the conversion and may be called concurrently. Each input message
+// is copied before it's handed to a worker, so split may reuse its own
+// message space.
+//
+// At most n messages are queued at any time, in addition to the ones that
+// are being worked on. The first error stops all further work, and is
+// returned after the messages preceding it. The workers are started on the
+// first read and stopped by Close, which may be called while another
+// goroutine is reading. They're also stopped once the context that's bound
+// at the first read is done, and a bound context cancels any read that's
+// waiting on a result.
+func Parallel(n int, split func() ([]byte, error), work func([]byte) ([]byte, error)) *Transformer {
+	if n < 1 {
+		n = 1
+	}
+	queue := make(chan chan parallelResult, n)
+	done := make(chan struct{})
+	var startOnce, stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() { close(done) })
+	}
+	start := func(ctx context.Context) {
+		jobs := make(chan parallelJob)
+		for i := 0; i < n; i++ {
+			go func() {
+				for job := range jobs {
+					data, err := work(job.data)
+					job.res <- parallelResult{data, err}
+				}
+			}()
+		}
+		go func() {
+			defer close(queue)
+			defer close(jobs)
+			for {
+				data, err := split()
+				if len(data) > 0 {
+					// queue the result slot prior to handing the job to a
+					// worker, that's what keeps the output in order.
+					job := parallelJob{
+						data: append([]byte(nil), data...),
+						res:  make(chan parallelResult, 1),
+					}
+					select {
+					case queue <- job.res:
+					case <-ctx.Done():
+						return
+					case <-done:
+						return
+					}
+					select {
+					case jobs <- job:
+					case <-ctx.Done():
+						return
+					case <-done:
+						return
+					}
+				}
+				if err != nil {
+					res := make(chan parallelResult, 1)
+					res <- parallelResult{err: err}
+					select {
+					case queue <- res:
+					case <-ctx.Done():
+					case <-done:
+					}
+					return
+				}
+			}
+		}()
+	}
+	var err error
+	return NewTransformerContext(context.Background(), func(ctx context.Context) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		startOnce.Do(func() { start(ctx) })
+		var res chan parallelResult
+		var ok bool
+		select {
+		case res, ok = <-queue:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-done:
+		}
+		if !ok {
+			err = ErrClosed
+			return nil, err
+		}
+		var r parallelResult
+		select {
+		case r = <-res:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-done:
+			err = ErrClosed
+			return nil, err
+		}
+		if r.err != nil {
+			err = r.err
+			stop()
+		}
+		return r.data, r.err
+	}).OnClose(func() error {
+		stop()
+		return nil
+	})
+}
@@ -0,0 +1,146 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	var in, expect bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&in, "line %d\n", i)
+		fmt.Fprintf(&expect, "LINE %d\n", i)
+	}
+	br := bufio.NewReader(&in)
+	var active, maxActive int32
+	r := Parallel(8, func() ([]byte, error) {
+		return br.ReadBytes('\n')
+	}, func(msg []byte) ([]byte, error) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+		return bytes.ToUpper(msg), nil
+	})
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(out); out != expect.String() {
+		t.Fatalf("expected '%v', got '%v'\n", expect.String(), out)
+	}
+	if maxActive > 8 {
+		t.Fatalf("expected at most 8 workers, got %d", maxActive)
+	}
+}
+
+func TestParallelError(t *testing.T) {
+	errBad := errors.New("bad")
+	var i, worked int32
+	r := Parallel(4, func() ([]byte, error) {
+		i++
+		return []byte(fmt.Sprint(i)), nil
+	}, func(msg []byte) ([]byte, error) {
+		atomic.AddInt32(&worked, 1)
+		if string(msg) == "5" {
+			return nil, errBad
+		}
+		return msg, nil
+	})
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != errBad {
+		t.Fatalf("expected '%v', got '%v'\n", errBad, err)
+	}
+	if string(out) != "1234" {
+		t.Fatalf("expected '%v', got '%v'\n", "1234", string(out))
+	}
+	if _, err := r.ReadMessage(); err != errBad {
+		t.Fatalf("expected '%v', got '%v'\n", errBad, err)
+	}
+	time.Sleep(time.Millisecond * 10)
+	if n := atomic.LoadInt32(&worked); n > 5+4*2+1 {
+		t.Fatalf("expected work to stop, got %d messages", n)
+	}
+}
+
+func TestParallelClose(t *testing.T) {
+	r := Parallel(4, func() ([]byte, error) {
+		return []byte("x"), nil
+	}, func(msg []byte) ([]byte, error) {
+		return msg, nil
+	})
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+	}
+}
+
+func TestParallelCloseWhileReading(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	var once sync.Once
+	r := Parallel(1, func() ([]byte, error) {
+		return []byte("x"), nil
+	}, func(msg []byte) ([]byte, error) {
+		once.Do(func() { close(started) })
+		<-release
+		return msg, nil
+	})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.ReadMessage()
+		errc <- err
+	}()
+	<-started
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != ErrClosed {
+			t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("read was not unblocked by close")
+	}
+}
+
+func TestParallelCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := Parallel(2, func() ([]byte, error) {
+		return []byte("x"), nil
+	}, func(msg []byte) ([]byte, error) {
+		<-release
+		return msg, nil
+	}).BindContext(ctx)
+	defer r.Close()
+	time.AfterFunc(time.Millisecond*10, cancel)
+	if _, err := r.ReadMessage(); err != context.Canceled {
+		t.Fatalf("expected '%v', got '%v'\n", context.Canceled, err)
+	}
+}
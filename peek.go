@@ -0,0 +1,75 @@
+package transform
+
+// PeekMessage returns the next message without consuming it. The message is
+// returned again by the following read, along with any error that came with
+// it. The returned message must not be modified.
+//
+// Peeking allows for stages that sniff the content of a stream, such as
+// detecting its format, before deciding how to read it.
+func (r *Transformer) PeekMessage() ([]byte, error) {
+	var cp Checkpoint
+	peeked := r.cpfn != nil && len(r.unread) == 0
+	if peeked {
+		cp, _ = r.Checkpoint()
+	}
+	msg, err := r.ReadMessage()
+	if len(msg) > 0 {
+		r.UnreadMessage(msg)
+		r.peeked, r.peekcp = peeked, cp
+	}
+	if err != nil {
+		r.uerr = err
+	}
+	return msg, err
+}
+
+// UnreadMessage pushes a message back onto the transformer, to be returned
+// by the following read. Messages are returned in the reverse order that
+// they're pushed back, and prior to any data that's waiting in the read
+// buffer from a previous Read. The message is copied.
+func (r *Transformer) UnreadMessage(msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+	if pending := len(r.buf) - r.idx; pending > 0 {
+		// the remainder of the partially read message goes first.
+		r.unread = append(r.unread, append([]byte(nil), r.buf[r.idx:]...))
+		r.buf = r.buf[:0]
+		r.idx = 0
+	}
+	r.unread = append(r.unread, append([]byte(nil), msg...))
+	r.peeked = false
+}
+
+// readUnread returns the data that's waiting in the read buffer, the
+// messages that were pushed back, or the error that followed a peeked
+// message, in that order.
+func (r *Transformer) readUnread() ([]byte, error) {
+	if pending := len(r.buf) - r.idx; pending > 0 {
+		msg := r.buf[r.idx:]
+		r.buf = r.buf[:0]
+		r.idx = 0
+		return msg, nil
+	}
+	if n := len(r.unread); n > 0 {
+		msg := r.unread[n-1]
+		r.unread = r.unread[:n-1]
+		r.peeked = false
+		if n == 1 && r.uerr != nil {
+			err := r.uerr
+			r.uerr = nil
+			return msg, err
+		}
+		return msg, nil
+	}
+	err := r.uerr
+	r.uerr = nil
+	return nil, err
+}
+
+// hasUnread reports whether there are messages that were pushed back, or an
+// error that follows a peeked message. These are returned even after Read
+// or WriteTo encountered an error.
+func (r *Transformer) hasUnread() bool {
+	return len(r.unread) > 0 || r.uerr != nil
+}
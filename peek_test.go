@@ -0,0 +1,94 @@
+package transform
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPeekMessage(t *testing.T) {
+	r := lineMessages("alpha\nbeta\ngamma")
+	for i := 0; i < 2; i++ {
+		msg, err := r.PeekMessage()
+		if err != nil || string(msg) != "alpha\n" {
+			t.Fatalf("expected '%v', got '%v'\n", "alpha\n", string(msg))
+		}
+	}
+	if msg, _ := r.ReadMessage(); string(msg) != "alpha\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "alpha\n", string(msg))
+	}
+
+	// a peeked message is read byte by byte with Read.
+	r.PeekMessage()
+	p := make([]byte, 2)
+	if _, err := io.ReadFull(r, p); err != nil || string(p) != "be" {
+		t.Fatalf("expected '%v', got '%v'\n", "be", string(p))
+	}
+	// the remainder of the message comes before anything that's unread.
+	r.UnreadMessage([]byte("delta\n"))
+	if msg, _ := r.PeekMessage(); string(msg) != "delta\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "delta\n", string(msg))
+	}
+	if msg, _ := r.ReadMessage(); string(msg) != "delta\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "delta\n", string(msg))
+	}
+	if msg, _ := r.ReadMessage(); string(msg) != "ta\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "ta\n", string(msg))
+	}
+
+	// the error that comes with the last message is peeked too.
+	msg, err := r.PeekMessage()
+	if string(msg) != "gamma" || err != io.EOF {
+		t.Fatalf("expected '%v', got '%v'\n", "gamma, EOF", string(msg)+", "+err.Error())
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != "gamma" {
+		t.Fatalf("expected '%v', got '%v'\n", "gamma", string(data))
+	}
+}
+
+func TestUnreadAfterEOF(t *testing.T) {
+	r := lineMessages("alpha\n")
+	if data := string(mustReadAll(t, r)); data != "alpha\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "alpha\n", data)
+	}
+	// a message that's unread after io.EOF is returned by Read.
+	r.UnreadMessage([]byte("beta\n"))
+	if data := string(mustReadAll(t, r)); data != "beta\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "beta\n", data)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("expected '%v', got '%v'\n", io.EOF, err)
+	}
+	// and by WriteTo.
+	r.UnreadMessage([]byte("gamma\n"))
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil || b.String() != "gamma\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "gamma\n", b.String())
+	}
+}
+
+func TestPeekCheckpoint(t *testing.T) {
+	input := "lacy timber\nhybrid gossiping\ncoy radioactivity\n"
+	r := upperLines(strings.NewReader(input), Checkpoint{})
+	p := make([]byte, 5)
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+	expect, _ := r.Checkpoint()
+	if msg, _ := r.PeekMessage(); string(msg) != "TIMBER\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "TIMBER\n", string(msg))
+	}
+	if cp, err := r.Checkpoint(); err != nil || cp.Offset != expect.Offset || cp.Skip != expect.Skip {
+		t.Fatalf("expected '%v', got '%v'\n", expect, cp)
+	}
+	r.UnreadMessage([]byte("X"))
+	if _, err := r.Checkpoint(); err != ErrNoCheckpoint {
+		t.Fatalf("expected '%v', got '%v'\n", ErrNoCheckpoint, err)
+	}
+	rest, _ := ioutil.ReadAll(r)
+	if string(p)+string(rest) != "LACY XTIMBER\nHYBRID GOSSIPING\nCOY RADIOACTIVITY\n" {
+		t.Fatalf("unexpected output %q", string(p)+string(rest))
+	}
+}
@@ -0,0 +1,97 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StageError is returned by a Pipeline when one of its stages fails.
+type StageError struct {
+	Stage  string // name of the stage that failed
+	Index  int64  // number of messages that the stage emitted prior to failing
+	Offset int64  // input offset of the failure, see Pipeline.Then
+	Err    error  // the underlying error
+}
+
+// Error conforms to the error interface.
+func (e *StageError) Error() string {
+	return fmt.Sprintf("transform: stage %q failed at message %d, offset %d: %v",
+		e.Stage, e.Index, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline is a builder for a chain of named transformers.
+type Pipeline struct {
+	r *Transformer // outermost stage
+}
+
+// NewPipeline returns a pipeline that reads from src.
+func NewPipeline(src io.Reader) *Pipeline {
+	return &Pipeline{r: messages(src)}
+}
+
+// Then appends a stage to the pipeline. The stage param is a transformer
+// constructor, such as transutil.Gunzipper.
+//
+// Any error returned by the stage, other than io.EOF, is wrapped in a
+// *StageError. Errors from previous stages are passed along unchanged, thus
+// the returned error always identifies the first stage that failed.
+//
+// When the stage supports checkpoints, the Offset of the error is the input
+// offset of the message that failed. Otherwise it's the number of input
+// bytes that were handed to the stage, which includes any input that the
+// stage read ahead of the failure, such as into a bufio.Reader.
+// Returns the pipeline.
+func (p *Pipeline) Then(name string, stage func(r io.Reader) *Transformer) *Pipeline {
+	prev := p.r
+	var consumed int64
+	in := NewTransformer(func() ([]byte, error) {
+		msg, err := prev.ReadMessage()
+		consumed += int64(len(msg))
+		return msg, err
+	}).Upstream(prev)
+	t := stage(in)
+	var index int64
+	p.r = NewTransformer(func() ([]byte, error) {
+		msg, err := t.ReadMessage()
+		if err == nil {
+			index++
+			return msg, nil
+		}
+		var serr *StageError
+		if err != io.EOF && !errors.As(err, &serr) {
+			// exclude the input that's still in the read buffer.
+			offset := consumed - int64(len(in.buf)-in.idx)
+			if t.cpfn != nil {
+				// the checkpoint prior to the message that failed.
+				offset = t.cp.Offset
+			}
+			err = &StageError{Stage: name, Index: index, Offset: offset, Err: err}
+		}
+		return msg, err
+	}).Upstream(t)
+	return p
+}
+
+// Transformer returns the outermost stage of the pipeline.
+func (p *Pipeline) Transformer() *Transformer {
+	return p.r
+}
+
+// messages returns a transformer for reading messages from r. When r is
+// already a transformer it's returned as is, otherwise it's read in chunks.
+func messages(r io.Reader) *Transformer {
+	if t, ok := r.(*Transformer); ok {
+		return t
+	}
+	buf := make([]byte, 4096)
+	return NewTransformer(func() ([]byte, error) {
+		n, err := r.Read(buf)
+		return buf[:n], err
+	}).Upstream(r)
+}
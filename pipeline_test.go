@@ -0,0 +1,107 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	errBad := errors.New("bad line")
+	lines := func(r io.Reader) *Transformer {
+		br := bufio.NewReader(r)
+		return NewTransformer(func() ([]byte, error) {
+			line, err := br.ReadBytes('\n')
+			if bytes.HasPrefix(line, []byte("bad")) {
+				return nil, errBad
+			}
+			return line, err
+		}).Upstream(r)
+	}
+	r := NewPipeline(bytes.NewBufferString("uryyb\njbeyq\n")).
+		Then("rot13", Rot13).
+		Then("lines", lines).
+		Transformer()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello\nworld\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "hello\nworld\n", string(out))
+	}
+	// failure in the last stage
+	r = NewPipeline(bytes.NewBufferString("uryyb\njbeyq\nonq\n")).
+		Then("rot13", Rot13).
+		Then("lines", lines).
+		Transformer()
+	_, err = ioutil.ReadAll(r)
+	var serr *StageError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a stage error, got '%v'", err)
+	}
+	if serr.Stage != "lines" || serr.Index != 2 || !errors.Is(err, errBad) {
+		t.Fatalf("unexpected stage error '%v'", err)
+	}
+	// failure in the first stage
+	r = NewPipeline(bytes.NewBufferString("bad\nuryyb\n")).
+		Then("lines", lines).
+		Then("rot13", Rot13).
+		Transformer()
+	_, err = ioutil.ReadAll(r)
+	if !errors.As(err, &serr) || serr.Stage != "lines" || serr.Index != 0 {
+		t.Fatalf("unexpected stage error '%v'", err)
+	}
+}
+
+func TestPipelineOffset(t *testing.T) {
+	errBad := errors.New("bad line")
+	// lines reads ahead of the line that fails.
+	lines := func(r io.Reader) *Transformer {
+		br := bufio.NewReader(r)
+		return NewTransformer(func() ([]byte, error) {
+			line, err := br.ReadBytes('\n')
+			if bytes.HasPrefix(line, []byte("bad")) {
+				return nil, errBad
+			}
+			return line, err
+		}).Upstream(r)
+	}
+	input := "good 1\ngood 2\nbad 3\ngood 4\ngood 5\n"
+	_, err := ioutil.ReadAll(NewPipeline(bytes.NewBufferString(input)).
+		Then("lines", lines).
+		Transformer())
+	var serr *StageError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a stage error, got '%v'", err)
+	}
+	// without checkpoints, all of the input was handed to the stage.
+	if serr.Offset != int64(len(input)) {
+		t.Fatalf("expected '%v', got '%v'\n", len(input), serr.Offset)
+	}
+	checkpointed := func(r io.Reader) *Transformer {
+		br := bufio.NewReader(r)
+		var offset int64
+		return NewTransformer(func() ([]byte, error) {
+			line, err := br.ReadBytes('\n')
+			if bytes.HasPrefix(line, []byte("bad")) {
+				return nil, errBad
+			}
+			offset += int64(len(line))
+			return line, err
+		}).Upstream(r).OnCheckpoint(func() Checkpoint {
+			return Checkpoint{Offset: offset}
+		})
+	}
+	_, err = ioutil.ReadAll(NewPipeline(bytes.NewBufferString(input)).
+		Then("lines", checkpointed).
+		Transformer())
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected a stage error, got '%v'", err)
+	}
+	if serr.Offset != 14 || serr.Index != 2 {
+		t.Fatalf("expected '%v', got '%v'\n", "offset 14 at message 2", serr)
+	}
+}
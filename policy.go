@@ -0,0 +1,85 @@
+package transform
+
+import "io"
+
+// MessageError is returned by a transform function when a single message
+// can't be converted. It's the only kind of error that SkipErrors and
+// DeadLetter recover from, all other errors stop the transformer.
+type MessageError struct {
+	// Raw is the raw input of the message. The converters that decode
+	// their input with a streaming decoder, such as MsgPackToJSON,
+	// YAMLToJSON, and CBORToJSON, don't have the original bytes, in which
+	// case Raw is the decoded value re-encoded in the input format.
+	Raw []byte
+	Err error // the underlying error
+
+	stage *Transformer // transformer whose transform function failed
+}
+
+// Error conforms to the error interface.
+func (e *MessageError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// SkipErrors makes the transformer skip the messages that fail with a
+// *MessageError that's returned by its own transform function, rather than
+// stopping. The fn param, when not nil, is called with the raw input and the
+// underlying error of each skipped message. Returns the transformer.
+func (r *Transformer) SkipErrors(fn func(raw []byte, err error)) *Transformer {
+	r.onskip = func(raw []byte, err error) error {
+		if fn != nil {
+			fn(raw, err)
+		}
+		return nil
+	}
+	return r
+}
+
+// DeadLetter is like SkipErrors, but also writes the raw input of each
+// skipped message to w, followed by a newline. Thus, for the JSON
+// converters, w receives the malformed records in newline-delimited form.
+// An error writing to w stops the transformer. Returns the transformer.
+func (r *Transformer) DeadLetter(w io.Writer, fn func(raw []byte, err error)) *Transformer {
+	r.onskip = func(raw []byte, err error) error {
+		if _, werr := w.Write(append(raw[:len(raw):len(raw)], '\n')); werr != nil {
+			return werr
+		}
+		if fn != nil {
+			fn(raw, err)
+		}
+		return nil
+	}
+	return r
+}
+
+// skipError applies the error policy to an error returned by the transform
+// function. Returns true when the message should be skipped, otherwise the
+// error may be replaced by an error of the policy itself.
+func (r *Transformer) skipError(err *error) bool {
+	merr, ok := (*err).(*MessageError)
+	if !ok {
+		return false
+	}
+	if merr.stage == nil {
+		// the error is new, thus it's from this transformer's own transform
+		// function, rather than passed along from an upstream transformer.
+		merr.stage = r
+	}
+	// an upstream transformer that failed keeps failing.
+	if r.onskip == nil || merr.stage != r {
+		return false
+	}
+	if r.obs != nil {
+		r.obs.OnError(*err)
+	}
+	if perr := r.onskip(merr.Raw, merr.Err); perr != nil {
+		*err = perr
+		return false
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numberLines emits the lines that are numbers, and fails with a
+// *MessageError on all other lines.
+func numberLines(s string) *Transformer {
+	br := bufio.NewReader(strings.NewReader(s))
+	return NewTransformer(func() ([]byte, error) {
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if _, err := strconv.Atoi(string(line)); err != nil {
+			return nil, &MessageError{Raw: line, Err: err}
+		}
+		return append(line, ','), nil
+	})
+}
+
+func TestErrorPolicies(t *testing.T) {
+	input := "1\n2\nthree\n4\nfive\n6\n"
+
+	// the default policy stops at the first bad message.
+	_, err := ioutil.ReadAll(numberLines(input))
+	var merr *MessageError
+	if !errors.As(err, &merr) || string(merr.Raw) != "three" {
+		t.Fatalf("expected '%v', got '%v'\n", "three", err)
+	}
+
+	var skipped []string
+	r := numberLines(input).SkipErrors(func(raw []byte, err error) {
+		if _, ok := err.(*strconv.NumError); !ok {
+			t.Fatalf("expected '%v', got '%T'\n", "*strconv.NumError", err)
+		}
+		skipped = append(skipped, string(raw))
+	})
+	if out := string(mustReadAll(t, r)); out != "1,2,4,6," {
+		t.Fatalf("expected '%v', got '%v'\n", "1,2,4,6,", out)
+	}
+	if strings.Join(skipped, " ") != "three five" {
+		t.Fatalf("expected '%v', got '%v'\n", "three five", skipped)
+	}
+
+	var dead bytes.Buffer
+	r = numberLines(input).DeadLetter(&dead, nil)
+	if out := string(mustReadAll(t, r)); out != "1,2,4,6," {
+		t.Fatalf("expected '%v', got '%v'\n", "1,2,4,6,", out)
+	}
+	if dead.String() != "three\nfive\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "three\nfive\n", dead.String())
+	}
+
+	// stream errors aren't skipped.
+	r = NewTransformer(func() ([]byte, error) {
+		return nil, errors.New("broken")
+	}).SkipErrors(nil)
+	if _, err := r.ReadMessage(); err == nil || err.Error() != "broken" {
+		t.Fatalf("expected '%v', got '%v'\n", "broken", err)
+	}
+
+	// message errors of an upstream transformer aren't skipped either.
+	var calls int
+	upstream := numberLines(input)
+	r = NewTransformer(func() ([]byte, error) {
+		return upstream.ReadMessage()
+	}).Upstream(upstream).SkipErrors(func(raw []byte, err error) { calls++ })
+	if _, err := ioutil.ReadAll(r); !errors.As(err, &merr) || string(merr.Raw) != "three" {
+		t.Fatalf("expected '%v', got '%v'\n", "three", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected '%v', got '%v'\n", 0, calls)
+	}
+}
@@ -0,0 +1,45 @@
+package transform
+
+import "context"
+
+// Prefetch returns a transformer that reads the messages of t ahead of time
+// in a background goroutine, allowing for slow upstream I/O and slow
+// downstream processing to overlap. At most depth messages are queued.
+//
+// The goroutine is started on the first read. Errors from t are returned
+// after the messages preceding them, and a bound context cancels any read
+// that's waiting on the queue.
+//
+// Close stops the goroutine and closes t, and it may be called while another
+// goroutine is reading. A read of t that's in progress is interrupted when
+// the furthest upstream reader supports read deadlines, such as a net.Conn.
+// Otherwise, Close returns without waiting and t is closed once the read
+// returns.
+func Prefetch(t *Transformer, depth int) *Transformer {
+	if depth < 1 {
+		depth = 1
+	}
+	ch := make(chan message, depth)
+	p := newPumps()
+	var err error
+	return NewTransformerContext(context.Background(), func(ctx context.Context) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		p.start(func() { p.pump(t, ch) })
+		select {
+		case msg := <-ch:
+			if msg.err != nil {
+				err = msg.err
+				p.halt()
+			}
+			return msg.data, msg.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.done:
+			return nil, ErrClosed
+		}
+	}).sources(t).OnClose(func() error {
+		return p.stop(t.Close, t)
+	})
+}
@@ -0,0 +1,174 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	var ahead int32
+	src := counterMessages(100)
+	r := Prefetch(NewTransformer(func() ([]byte, error) {
+		atomic.AddInt32(&ahead, 1)
+		return src.ReadMessage()
+	}), 10)
+	defer r.Close()
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 10)
+	if n := atomic.LoadInt32(&ahead); n < 10 {
+		t.Fatalf("expected at least 10 messages to be read ahead, got %d", n)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = append([]byte("1,"), out...)
+	if string(out) != string(mustReadAll(t, counterMessages(100))) {
+		t.Fatalf("unexpected output %q", out)
+	}
+	// errors
+	errBad := errors.New("bad")
+	var i int
+	r = Prefetch(NewTransformer(func() ([]byte, error) {
+		if i++; i > 2 {
+			return nil, errBad
+		}
+		return []byte("x"), nil
+	}), 4)
+	defer r.Close()
+	out, err = ioutil.ReadAll(r)
+	if err != errBad || string(out) != "xx" {
+		t.Fatalf("unexpected result '%s', '%v'", out, err)
+	}
+}
+
+func TestPrefetchCancel(t *testing.T) {
+	ch := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := Prefetch(chanMessages(ch), 4).BindContext(ctx)
+	defer r.Close()
+	go func() {
+		ch <- "a"
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+	}()
+	out, err := ioutil.ReadAll(r)
+	if err != context.Canceled || string(out) != "a" {
+		t.Fatalf("unexpected result '%s', '%v'", out, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadMessage(); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+	}
+	close(ch) // release the pending read of the source
+}
+
+var closeStages = map[string]func(src *Transformer) *Transformer{
+	"prefetch": func(src *Transformer) *Transformer { return Prefetch(src, 1) },
+	"merge":    func(src *Transformer) *Transformer { return Merge(src) },
+	"batch": func(src *Transformer) *Transformer {
+		return Batch(src, &BatchOptions{Interval: time.Hour}, joinBatch)
+	},
+	"window": func(src *Transformer) *Transformer { return Window(src, time.Hour, nil, joinBatch) },
+	"tee":    func(src *Transformer) *Transformer { return Tee(src, 1, nil)[0] },
+}
+
+// TestCloseWhileReading checks that the stages with a background goroutine
+// don't close their source while the goroutine is reading from it, and
+// that Close doesn't wait for the read.
+func TestCloseWhileReading(t *testing.T) {
+	for name, stage := range closeStages {
+		var busy int32
+		var once sync.Once
+		reading := make(chan struct{})
+		release := make(chan struct{})
+		closed := make(chan error, 1)
+		src := NewTransformer(func() ([]byte, error) {
+			atomic.StoreInt32(&busy, 1)
+			defer atomic.StoreInt32(&busy, 0)
+			once.Do(func() { close(reading) })
+			<-release
+			return nil, io.EOF
+		}).OnClose(func() error {
+			if atomic.LoadInt32(&busy) != 0 {
+				closed <- errors.New("closed while reading")
+			} else {
+				closed <- nil
+			}
+			return nil
+		})
+		r := stage(src)
+		errc := make(chan error, 1)
+		go func() {
+			_, err := r.ReadMessage()
+			errc <- err
+		}()
+		<-reading
+		if err := r.Close(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := <-errc; err != ErrClosed {
+			t.Fatalf("%s: expected '%v', got '%v'\n", name, ErrClosed, err)
+		}
+		select {
+		case <-closed:
+			t.Fatalf("%s: source closed prior to the read returning", name)
+		case <-time.After(time.Millisecond * 10):
+		}
+		close(release)
+		if err := <-closed; err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestCloseInterruptsRead checks that Close interrupts a blocked read of a
+// source that supports read deadlines, and then closes the source.
+func TestCloseInterruptsRead(t *testing.T) {
+	for name, stage := range closeStages {
+		c1, c2 := net.Pipe()
+		r := stage(Rot13(c1))
+		errc := make(chan error, 1)
+		go func() {
+			_, err := r.ReadMessage()
+			errc <- err
+		}()
+		time.Sleep(time.Millisecond * 10)
+		closed := make(chan error, 1)
+		go func() { closed <- r.Close() }()
+		select {
+		case err := <-closed:
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: close is blocked by the pending read", name)
+		}
+		if err := <-errc; err != ErrClosed {
+			t.Fatalf("%s: expected '%v', got '%v'\n", name, ErrClosed, err)
+		}
+		if _, err := c2.Write([]byte("x")); err != io.ErrClosedPipe {
+			t.Fatalf("%s: expected '%v', got '%v'\n", name, io.ErrClosedPipe, err)
+		}
+		c2.Close()
+	}
+}
+
+func mustReadAll(t *testing.T, r *Transformer) []byte {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
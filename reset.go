@@ -0,0 +1,45 @@
+package transform
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// ErrNoReset is returned by Reset when the transformer doesn't support
+// being reset.
+var ErrNoReset = errors.New("transform: reset not supported")
+
+// OnReset registers a function that rebinds the stage to a new source,
+// discarding any state from the previous one while keeping its allocations,
+// such as decoders and buffers. Returns the transformer.
+func (r *Transformer) OnReset(fn func(src io.Reader) error) *Transformer {
+	r.resetfn = fn
+	return r
+}
+
+// Reset rebinds the transformer to a new source, allowing for a chain of
+// transformers to be pooled and reused rather than allocated for each
+// stream. It's the equivalent of gzip.Writer.Reset.
+//
+// The transformer is returned to its initial state, including when it was
+// closed, while the functions registered with OnClose, OnCheckpoint,
+// Observe, UsePool, SkipErrors, and DeadLetter remain in effect. Only the
+// transformer itself is reset, thus each stage of a chain is reset to its
+// upstream stage, starting with the innermost.
+func (r *Transformer) Reset(src io.Reader) error {
+	if r.resetfn == nil {
+		return ErrNoReset
+	}
+	if err := r.resetfn(src); err != nil {
+		return err
+	}
+	r.buf = r.buf[:0]
+	r.idx = 0
+	r.err = nil
+	r.src = src
+	r.unread, r.uerr, r.peeked = nil, nil, false
+	r.cp, r.cplen, r.base, r.count = Checkpoint{}, 0, Checkpoint{}, 0
+	atomic.StoreInt32(&r.closed, 0)
+	return nil
+}
@@ -0,0 +1,49 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// resettableUpper converts lines to upper case and supports Reset.
+func resettableUpper(r io.Reader) *Transformer {
+	br := bufio.NewReader(r)
+	return NewTransformer(func() ([]byte, error) {
+		line, err := br.ReadBytes('\n')
+		return bytes.ToUpper(line), err
+	}).Upstream(r).OnReset(func(src io.Reader) error {
+		br.Reset(src)
+		return nil
+	})
+}
+
+func TestReset(t *testing.T) {
+	if err := NewTransformer(nil).Reset(nil); err != ErrNoReset {
+		t.Fatalf("expected '%v', got '%v'\n", ErrNoReset, err)
+	}
+	inner := resettableUpper(strings.NewReader("hello\nworld\n"))
+	outer := resettableUpper(inner)
+	p := make([]byte, 3)
+	if _, err := io.ReadFull(outer, p); err != nil {
+		t.Fatal(err)
+	}
+	outer.Close()
+	if _, err := outer.Read(p); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+	}
+	for _, input := range []string{"lacy timber\n", "hybrid gossiping\n"} {
+		if err := inner.Reset(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+		if err := outer.Reset(inner); err != nil {
+			t.Fatal(err)
+		}
+		out := string(mustReadAll(t, outer))
+		if out != strings.ToUpper(input) {
+			t.Fatalf("expected '%v', got '%v'\n", strings.ToUpper(input), out)
+		}
+	}
+}
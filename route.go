@@ -0,0 +1,56 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Route returns a transformer that dispatches each message of src to one of
+// the routes, as chosen by the selector, and emits the outputs of the routes
+// in the same order as their messages. Message boundaries are preserved when
+// src is a transformer, otherwise it's read in chunks.
+//
+// Each route is a transformer constructor, such as transutil.JSONToProtoBuf,
+// that's called with a reader of a single message, and its entire output is
+// emitted as a single message. Messages that result in no output are
+// skipped. The route registered for "" is the default for selector results
+// that have no route of their own.
+//
+// A message that can't be routed, or that fails in its route, results in a
+// *MessageError, which allows for skipping it with SkipErrors.
+func Route(src io.Reader, selector func(msg []byte) string, routes map[string]func(r io.Reader) io.Reader) *Transformer {
+	m := messages(src)
+	var in bytes.Reader
+	var out bytes.Buffer
+	return NewTransformer(func() ([]byte, error) {
+		for {
+			msg, err := m.ReadMessage()
+			if len(msg) == 0 {
+				return nil, err
+			}
+			key := selector(msg)
+			route, ok := routes[key]
+			if !ok {
+				route, ok = routes[""]
+			}
+			if !ok {
+				return nil, &MessageError{Raw: msg,
+					Err: fmt.Errorf("transform: no route for %q", key)}
+			}
+			in.Reset(msg)
+			out.Reset()
+			r := route(&in)
+			_, rerr := out.ReadFrom(r)
+			if c, ok := r.(io.Closer); ok {
+				c.Close()
+			}
+			if rerr != nil {
+				return nil, &MessageError{Raw: msg, Err: rerr}
+			}
+			if out.Len() > 0 || err != nil {
+				return out.Bytes(), err
+			}
+		}
+	}).Upstream(m)
+}
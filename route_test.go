@@ -0,0 +1,62 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	upper := func(r io.Reader) io.Reader {
+		return NewTransformer(func() ([]byte, error) {
+			var buf [64]byte
+			n, err := r.Read(buf[:])
+			return bytes.ToUpper(buf[:n]), err
+		})
+	}
+	drop := func(r io.Reader) io.Reader { return bytes.NewReader(nil) }
+	fail := func(r io.Reader) io.Reader {
+		return NewTransformer(func() ([]byte, error) {
+			return nil, errors.New("broken")
+		})
+	}
+	selector := func(msg []byte) string {
+		return string(bytes.SplitN(msg, []byte(":"), 2)[0])
+	}
+	input := "event:a\nlog:b\nevent:c\nnoise:d\nlog:e\n"
+
+	routes := map[string]func(io.Reader) io.Reader{
+		"event": upper,
+		"log":   func(r io.Reader) io.Reader { return Rot13(r) },
+		"noise": drop,
+	}
+	out := string(mustReadAll(t, Route(lineMessages(input), selector, routes)))
+	if out != "EVENT:A\nybt:o\nEVENT:C\nybt:r\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "EVENT:A\nybt:o\nEVENT:C\nybt:r\n", out)
+	}
+
+	// without a default route, unknown messages fail.
+	delete(routes, "noise")
+	r := Route(lineMessages(input), selector, routes)
+	_, err := io.Copy(ioutil.Discard, r)
+	var merr *MessageError
+	if !errors.As(err, &merr) || string(merr.Raw) != "noise:d\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "noise:d\n", err)
+	}
+
+	// the default route.
+	routes[""] = upper
+	out = string(mustReadAll(t, Route(lineMessages(input), selector, routes)))
+	if out != "EVENT:A\nybt:o\nEVENT:C\nNOISE:D\nybt:r\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "EVENT:A\nybt:o\nEVENT:C\nNOISE:D\nybt:r\n", out)
+	}
+
+	// failed routes can be skipped.
+	routes["log"] = fail
+	out = string(mustReadAll(t, Route(lineMessages(input), selector, routes).SkipErrors(nil)))
+	if out != "EVENT:A\nEVENT:C\nNOISE:D\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "EVENT:A\nEVENT:C\nNOISE:D\n", out)
+	}
+}
@@ -0,0 +1,131 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// MaxFrameSize is the largest frame that a splitter accepts.
+const MaxFrameSize = 1 << 30
+
+// ErrFrameTooLarge is returned when a length-prefixed frame exceeds
+// MaxFrameSize.
+var ErrFrameTooLarge = errors.New("transform: frame too large")
+
+// NewSplitter returns a transformer that splits r into frames and emits
+// one message per frame. The split param follows the semantics of
+// bufio.SplitFunc, thus any of the bufio.Scan* functions may be used as
+// well as the framers provided by this package.
+func NewSplitter(r io.Reader, split bufio.SplitFunc) *Transformer {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), MaxFrameSize)
+	s.Split(split)
+	return NewTransformer(func() ([]byte, error) {
+		if s.Scan() {
+			return s.Bytes(), nil
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}).Upstream(r)
+}
+
+// SplitLines splits newline-delimited frames. The trailing newline, and
+// carriage return, are removed from each frame. It's the same as
+// bufio.ScanLines.
+func SplitLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	return bufio.ScanLines(data, atEOF)
+}
+
+// SplitNUL splits NUL-delimited frames. The trailing NUL byte is removed
+// from each frame.
+func SplitNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// SplitVarint splits frames that are prefixed with their size as an
+// unsigned varint, such as the output of transutil.JSONToProtoBuf in
+// multimessage mode. The prefix is removed from each frame.
+func SplitVarint(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	sz, n := binary.Uvarint(data)
+	if n < 0 || sz > MaxFrameSize {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if n > 0 && uint64(len(data)-n) >= sz {
+		return n + int(sz), data[n : n+int(sz)], nil
+	}
+	if atEOF {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return 0, nil, nil
+}
+
+// SplitUint32 splits frames that are prefixed with their size as a
+// big-endian uint32. The prefix is removed from each frame.
+func SplitUint32(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if len(data) >= 4 {
+		sz := binary.BigEndian.Uint32(data)
+		if sz > MaxFrameSize {
+			return 0, nil, ErrFrameTooLarge
+		}
+		if uint64(len(data)-4) >= uint64(sz) {
+			return 4 + int(sz), data[4 : 4+sz], nil
+		}
+	}
+	if atEOF {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return 0, nil, nil
+}
+
+// SplitJSONSeq splits RFC 7464 JSON text sequences, where each JSON text is
+// preceded by an ASCII record separator (0x1E) and followed by a newline.
+// The separator and surrounding whitespace are removed from each frame, and
+// empty records are skipped.
+func SplitJSONSeq(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	const rs = 0x1E
+	for advance < len(data) {
+		i := bytes.IndexByte(data[advance:], rs)
+		if i < 0 {
+			// discard anything that isn't part of a record.
+			return len(data), nil, nil
+		}
+		start := advance + i + 1
+		end := bytes.IndexByte(data[start:], rs)
+		if end >= 0 {
+			end += start
+		} else if atEOF {
+			end = len(data)
+		} else if data[len(data)-1] == '\n' && json.Valid(data[start:]) {
+			// a complete record doesn't need to wait for the next one.
+			end = len(data)
+		} else {
+			return advance + i, nil, nil
+		}
+		advance = end
+		if token = bytes.TrimSpace(data[start:end]); len(token) > 0 {
+			return advance, token, nil
+		}
+	}
+	return advance, nil, nil
+}
@@ -0,0 +1,87 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSplitter(t *testing.T) {
+	varint := func(msgs ...string) string {
+		var buf []byte
+		for _, msg := range msgs {
+			buf = append(buf, make([]byte, binary.MaxVarintLen64)...)
+			n := binary.PutUvarint(buf[len(buf)-binary.MaxVarintLen64:], uint64(len(msg)))
+			buf = append(buf[:len(buf)-binary.MaxVarintLen64+n], msg...)
+		}
+		return string(buf)
+	}
+	uint32be := func(msgs ...string) string {
+		var buf []byte
+		for _, msg := range msgs {
+			buf = append(buf, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(len(msg)))
+			buf = append(buf, msg...)
+		}
+		return string(buf)
+	}
+	long := strings.Repeat("x", 100000)
+	tests := []struct {
+		split  bufio.SplitFunc
+		input  string
+		expect []string
+		err    error
+	}{
+		{SplitLines, "a\nbc\r\n\nd", []string{"a", "bc", "", "d"}, nil},
+		{SplitNUL, "a\x00bc\x00\x00d", []string{"a", "bc", "", "d"}, nil},
+		{SplitNUL, "a\x00", []string{"a"}, nil},
+		{SplitVarint, varint("a", "", long, "bc"), []string{"a", "", long, "bc"}, nil},
+		{SplitVarint, varint("abc")[:3], nil, io.ErrUnexpectedEOF},
+		{SplitVarint, "\x80", nil, io.ErrUnexpectedEOF},
+		{SplitUint32, uint32be("a", "", long, "bc"), []string{"a", "", long, "bc"}, nil},
+		{SplitUint32, "\x00\x00", nil, io.ErrUnexpectedEOF},
+		{SplitUint32, "\xff\xff\xff\xff", nil, ErrFrameTooLarge},
+		{SplitJSONSeq, "\x1e{\"a\":1}\n\x1e\x1e[1,\n2]\n\x1e\"x\"", []string{`{"a":1}`, "[1,\n2]", `"x"`}, nil},
+		{SplitJSONSeq, "junk\x1e1\n", []string{"1"}, nil},
+	}
+	for i, tt := range tests {
+		r := NewSplitter(strings.NewReader(tt.input), tt.split)
+		var msgs []string
+		var err error
+		for {
+			var msg []byte
+			msg, err = r.ReadMessage()
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if err != tt.err {
+			t.Fatalf("test %d: expected '%v', got '%v'", i, tt.err, err)
+		}
+		if strings.Join(msgs, "|") != strings.Join(tt.expect, "|") || len(msgs) != len(tt.expect) {
+			t.Fatalf("test %d: expected %q, got %q", i, tt.expect, msgs)
+		}
+	}
+}
+
+func TestSplitJSONSeqStreaming(t *testing.T) {
+	// a complete record is emitted without waiting for the next one.
+	pr, pw := io.Pipe()
+	r := NewSplitter(pr, SplitJSONSeq)
+	go pw.Write([]byte("\x1e{\"a\":1}\n"))
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, []byte(`{"a":1}`)) {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	pw.Close()
+}
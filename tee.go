@@ -0,0 +1,194 @@
+package transform
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// ErrSlowConsumer is returned by a Tee output that fell behind while using
+// the TeeError policy.
+var ErrSlowConsumer = errors.New("transform: slow consumer")
+
+// TeePolicy determines what happens when a Tee output falls behind.
+type TeePolicy int
+
+const (
+	// TeeBlock waits for the slow output, which in turn holds up all other
+	// outputs.
+	TeeBlock TeePolicy = iota
+	// TeeDrop discards the messages that don't fit in the slow output's
+	// buffer.
+	TeeDrop
+	// TeeError fails the slow output with ErrSlowConsumer, which is returned
+	// after the messages that the output holds.
+	TeeError
+)
+
+// TeeOptions are the options for Tee.
+type TeeOptions struct {
+	// Buffer is the number of messages that each output may hold before
+	// it's considered slow. The default is 64.
+	Buffer int
+	// Policy for slow outputs. The default is TeeBlock.
+	Policy TeePolicy
+}
+
+type teeOutput struct {
+	ch   chan message  // pending messages, nil once detached, closed on failure
+	done chan struct{} // closed by Close
+	once sync.Once
+}
+
+func (o *teeOutput) close() {
+	o.once.Do(func() { close(o.done) })
+}
+
+// Tee returns n transformers that each receive every message read from src.
+// Message boundaries are preserved when src is a transformer. Each message
+// is shared by all outputs and must not be modified.
+//
+// The src is read by a background goroutine that's started on the first
+// read from any of the outputs. With the TeeDrop and TeeError policies the
+// goroutine keeps pace with the fastest output, and any output that's full
+// at that moment is considered slow. Passing nil for opts uses the default
+// options. Tee panics if n is negative.
+//
+// Closing an output detaches it from the others, and it may be called while
+// another goroutine is reading from that output. Once all outputs are closed
+// the goroutine is stopped and src is closed, like Prefetch.
+func Tee(src io.Reader, n int, opts *TeeOptions) []*Transformer {
+	var buffer int
+	var policy TeePolicy
+	if opts != nil {
+		buffer, policy = opts.Buffer, opts.Policy
+	}
+	if buffer <= 0 {
+		buffer = 64
+	}
+	if n < 0 {
+		panic("transform: negative Tee count")
+	}
+	m := messages(src)
+	outs := make([]*teeOutput, n)
+	for i := range outs {
+		outs[i] = &teeOutput{
+			ch:   make(chan message, buffer),
+			done: make(chan struct{}),
+		}
+	}
+	p := newPumps()
+	start := func() {
+		p.goroutine(func() {
+			for {
+				data, err := m.ReadMessage()
+				// copy the message, the source may repurpose it.
+				msg := message{append([]byte(nil), data...), err}
+				if !teeDeliver(outs, msg, policy) || err != nil {
+					return
+				}
+			}
+		})
+	}
+	var mu sync.Mutex
+	var open = n
+	ts := make([]*Transformer, n)
+	for i, o := range outs {
+		o, ch := o, o.ch
+		var err error
+		ts[i] = NewTransformer(func() ([]byte, error) {
+			if err != nil {
+				return nil, err
+			}
+			p.start(start)
+			var msg message
+			var ok bool
+			select {
+			case msg, ok = <-ch:
+				if !ok {
+					msg.err = ErrSlowConsumer
+				}
+			case <-o.done:
+				msg.err = ErrClosed
+			}
+			err = msg.err
+			return msg.data, msg.err
+		}).OnClose(func() error {
+			o.close()
+			mu.Lock()
+			defer mu.Unlock()
+			if open--; open == 0 {
+				// the goroutine exits once all outputs are detached.
+				return p.stop(func() error {
+					if c, ok := src.(io.Closer); ok {
+						return c.Close()
+					}
+					return nil
+				}, src)
+			}
+			return nil
+		})
+	}
+	return ts
+}
+
+// teeDeliver sends the message to all attached outputs. Returns false once
+// all outputs are detached.
+func teeDeliver(outs []*teeOutput, msg message, policy TeePolicy) bool {
+	var pending []*teeOutput
+	for _, o := range outs {
+		if o.ch != nil {
+			pending = append(pending, o)
+		}
+	}
+	if policy != TeeBlock && msg.err == nil {
+		// wait for the fastest output to take the message.
+		for len(pending) > 0 {
+			cases := make([]reflect.SelectCase, 0, len(pending)*2)
+			for _, o := range pending {
+				cases = append(cases,
+					reflect.SelectCase{Dir: reflect.SelectSend,
+						Chan: reflect.ValueOf(o.ch), Send: reflect.ValueOf(msg)},
+					reflect.SelectCase{Dir: reflect.SelectRecv,
+						Chan: reflect.ValueOf(o.done)})
+			}
+			i, _, _ := reflect.Select(cases)
+			o := pending[i/2]
+			pending = append(pending[:i/2], pending[i/2+1:]...)
+			if i%2 == 1 {
+				o.ch = nil
+				continue
+			}
+			break
+		}
+		// the remaining outputs are slow if they're full.
+		for _, o := range pending {
+			select {
+			case o.ch <- msg:
+			case <-o.done:
+				o.ch = nil
+			default:
+				if policy == TeeError {
+					// the output fails once it has read what it holds.
+					close(o.ch)
+					o.ch = nil
+				}
+			}
+		}
+	} else {
+		for _, o := range pending {
+			select {
+			case o.ch <- msg:
+			case <-o.done:
+				o.ch = nil
+			}
+		}
+	}
+	for _, o := range outs {
+		if o.ch != nil {
+			return true
+		}
+	}
+	return false
+}
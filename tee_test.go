@@ -0,0 +1,107 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func counterMessages(n int) *Transformer {
+	var i int
+	return NewTransformer(func() ([]byte, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		i++
+		return []byte(fmt.Sprintf("%d,", i)), nil
+	})
+}
+
+func TestTee(t *testing.T) {
+	var expect bytes.Buffer
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&expect, "%d,", i)
+	}
+	outs := Tee(counterMessages(1000), 3, &TeeOptions{Buffer: 4})
+	var wg sync.WaitGroup
+	results := make([][]byte, len(outs))
+	for i, out := range outs {
+		wg.Add(1)
+		go func(i int, out *Transformer) {
+			defer wg.Done()
+			for {
+				msg, err := out.ReadMessage()
+				results[i] = append(results[i], msg...)
+				if err != nil {
+					if err != io.EOF {
+						t.Error(err)
+					}
+					return
+				}
+			}
+		}(i, out)
+	}
+	wg.Wait()
+	for i := range results {
+		if string(results[i]) != expect.String() {
+			t.Fatalf("output %d mismatch", i)
+		}
+	}
+}
+
+func TestTeePolicies(t *testing.T) {
+	for _, policy := range []TeePolicy{TeeDrop, TeeError} {
+		outs := Tee(counterMessages(100), 2, &TeeOptions{Buffer: 2, Policy: policy})
+		fast, err := ioutil.ReadAll(outs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fast) != len("1,2,3,4,5,6,7,8,9,")+90*3+len("100,") {
+			t.Fatalf("fast output mismatch '%s'", fast)
+		}
+		slow, err := ioutil.ReadAll(outs[1])
+		switch policy {
+		case TeeDrop:
+			if err != nil || string(slow) != "1,2," {
+				t.Fatalf("unexpected drop result '%s', '%v'", slow, err)
+			}
+		case TeeError:
+			if err != ErrSlowConsumer || string(slow) != "1,2," {
+				t.Fatalf("unexpected error result '%s', '%v'", slow, err)
+			}
+		}
+	}
+}
+
+func TestTeeNegative(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	Tee(counterMessages(1), -1, nil)
+}
+
+func TestTeeClose(t *testing.T) {
+	src := &closeCounter{Reader: bytes.NewBufferString("hello world")}
+	outs := Tee(src, 2, nil)
+	if err := outs[1].Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(outs[0])
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("unexpected result '%s', '%v'", data, err)
+	}
+	if src.closed != 0 {
+		t.Fatal("closed early")
+	}
+	if err := outs[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if src.closed != 1 {
+		t.Fatal("not closed")
+	}
+}
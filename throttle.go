@@ -0,0 +1,148 @@
+package transform
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Clock is a source of time. It allows for testing time-dependent
+// transformers without waiting on the system clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives the time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the default clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// ThrottleOptions are the options for Throttle. Zero values are ignored.
+type ThrottleOptions struct {
+	// Messages is the maximum number of messages per second.
+	Messages float64
+	// Bytes is the maximum number of bytes per second.
+	Bytes float64
+	// Burst is the number of messages that may be emitted at once. The
+	// default is 1.
+	Burst int
+	// BurstBytes is the number of bytes that may be emitted at once. The
+	// default is one second's worth of Bytes.
+	BurstBytes int
+	// Timestamp enables replay mode. It returns the time at which a message
+	// was captured, and the messages are emitted with the same spacing. An
+	// error is returned as a *MessageError.
+	Timestamp func(msg []byte) (time.Time, error)
+	// Speed is the replay speed, such as 2 for twice as fast. The default
+	// is 1.
+	Speed float64
+	// Clock is the source of time. The default is the system clock.
+	Clock Clock
+}
+
+// bucket is a token bucket for rate limiting.
+type bucket struct {
+	rate   float64   // tokens per second, zero for unlimited
+	burst  float64   // maximum number of tokens
+	tokens float64   // available tokens, negative when in debt
+	last   time.Time // time of the last take
+}
+
+// take takes n tokens at time now. Returns how long to wait until the
+// tokens are available. Takes larger than the burst go into debt.
+func (b *bucket) take(now time.Time, n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if b.last.IsZero() {
+		b.tokens = b.burst
+	} else if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	var d time.Duration
+	if need := math.Min(n, b.burst); b.tokens < need {
+		d = time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.tokens = need
+	}
+	b.tokens -= n
+	b.last = now.Add(d)
+	return d
+}
+
+// Throttle returns a transformer that limits the pace at which the messages
+// of src are emitted. Message boundaries are preserved when src is a
+// transformer, otherwise it's read in chunks.
+//
+// Messages are delayed, never dropped. A message larger than BurstBytes is
+// emitted once the burst is available, and the following messages wait for
+// the difference. Close and a bound context interrupt a read that's
+// waiting. Passing nil for opts results in no limits.
+func Throttle(src io.Reader, opts *ThrottleOptions) *Transformer {
+	var o ThrottleOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Burst <= 0 {
+		o.Burst = 1
+	}
+	if o.BurstBytes <= 0 {
+		o.BurstBytes = int(math.Max(1, o.Bytes))
+	}
+	if o.Speed <= 0 {
+		o.Speed = 1
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	msgs := bucket{rate: o.Messages, burst: float64(o.Burst)}
+	bytes := bucket{rate: o.Bytes, burst: float64(o.BurstBytes)}
+	var first, start time.Time // replay origin
+	done := make(chan struct{})
+	var stopOnce sync.Once
+	m := messages(src)
+	return NewTransformerContext(context.Background(), func(ctx context.Context) ([]byte, error) {
+		msg, err := m.ReadMessage()
+		if len(msg) == 0 {
+			return nil, err
+		}
+		now := o.Clock.Now()
+		var d time.Duration
+		if o.Timestamp != nil {
+			ts, terr := o.Timestamp(msg)
+			if terr != nil {
+				return nil, &MessageError{Raw: msg, Err: terr}
+			}
+			if first.IsZero() {
+				first, start = ts, now
+			}
+			due := start.Add(time.Duration(float64(ts.Sub(first)) / o.Speed))
+			if due.After(now) {
+				d = due.Sub(now)
+			}
+		}
+		at := now.Add(d)
+		d1, d2 := msgs.take(at, 1), bytes.take(at, float64(len(msg)))
+		if d2 > d1 {
+			d1 = d2
+		}
+		if d += d1; d > 0 {
+			select {
+			case <-o.Clock.After(d):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-done:
+				return nil, ErrClosed
+			}
+		}
+		return msg, err
+	}).Upstream(m).OnClose(func() error {
+		stopOnce.Do(func() { close(done) })
+		return nil
+	})
+}
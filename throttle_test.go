@@ -0,0 +1,129 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock advances its time on each wait rather than sleeping.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestThrottle(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		opts    ThrottleOptions
+		input   *Transformer
+		elapsed time.Duration
+	}{
+		{ThrottleOptions{}, counterMessages(5), 0},
+		{ThrottleOptions{Messages: 10}, counterMessages(5), 400 * time.Millisecond},
+		{ThrottleOptions{Messages: 10, Burst: 3}, counterMessages(5), 200 * time.Millisecond},
+		// the first two messages fit in the burst, the rest take 0.5s each.
+		{ThrottleOptions{Bytes: 4}, counterMessages(5), 1500 * time.Millisecond},
+		// the byte limit dominates the message limit.
+		{ThrottleOptions{Messages: 10, Bytes: 4}, counterMessages(5), 1500 * time.Millisecond},
+		// a message larger than the burst waits for the full burst.
+		{ThrottleOptions{Bytes: 1, BurstBytes: 2}, lineMessages("a\nbcd\ne\n"), 6 * time.Second},
+	}
+	for i, tt := range tests {
+		clock := &fakeClock{now: start}
+		tt.opts.Clock = clock
+		out := mustReadAll(t, Throttle(tt.input, &tt.opts))
+		if len(out) == 0 {
+			t.Fatalf("%d: expected output", i)
+		}
+		if elapsed := clock.Now().Sub(start).Round(time.Millisecond); elapsed != tt.elapsed {
+			t.Fatalf("%d: expected '%v', got '%v'\n", i, tt.elapsed, elapsed)
+		}
+	}
+}
+
+func TestThrottleReplay(t *testing.T) {
+	timestamp := func(msg []byte) (time.Time, error) {
+		ms, err := strconv.ParseInt(string(bytes.TrimSpace(msg)), 10, 64)
+		return time.Unix(0, ms*int64(time.Millisecond)), err
+	}
+	for _, speed := range []float64{0, 1, 2} {
+		clock := &fakeClock{now: time.Unix(1000, 0)}
+		var stamps []time.Duration
+		r := Throttle(lineMessages("500\n600\n600\n850\n"), &ThrottleOptions{
+			Timestamp: timestamp, Speed: speed, Clock: clock,
+		})
+		for {
+			if _, err := r.ReadMessage(); err != nil {
+				break
+			}
+			stamps = append(stamps, clock.Now().Sub(time.Unix(1000, 0)))
+		}
+		div := time.Duration(1)
+		if speed == 2 {
+			div = 2
+		}
+		expect := []time.Duration{0, 100 / div, 100 / div, 350 / div}
+		for i := range expect {
+			expect[i] *= time.Millisecond
+		}
+		if len(stamps) != len(expect) {
+			t.Fatalf("expected '%v', got '%v'\n", expect, stamps)
+		}
+		for i := range expect {
+			if stamps[i] != expect[i] {
+				t.Fatalf("expected '%v', got '%v'\n", expect, stamps)
+			}
+		}
+	}
+
+	// bad timestamps can be skipped.
+	var skipped int
+	r := Throttle(lineMessages("1\nbad\n2\n"), &ThrottleOptions{
+		Timestamp: timestamp, Clock: &fakeClock{},
+	}).SkipErrors(func(raw []byte, err error) { skipped++ })
+	if out := string(mustReadAll(t, r)); out != "1\n2\n" || skipped != 1 {
+		t.Fatalf("expected '%v', got '%v'\n", "1\n2\n", out)
+	}
+}
+
+func TestThrottleClose(t *testing.T) {
+	r := Throttle(counterMessages(5), &ThrottleOptions{Messages: 0.001})
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(r)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Close()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read was not interrupted")
+	}
+}
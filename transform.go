@@ -2,12 +2,47 @@
 // format to another.
 package transform
 
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned when reading from a closed transformer.
+var ErrClosed = errors.New("transform: read from closed transformer")
+
 // Transformer represents a transform reader.
 type Transformer struct {
-	tfn func() ([]byte, error) // user-defined transform function
-	buf []byte                 // read buffer
-	idx int                    // read buffer index
-	err error                  // last error
+	tfn  func() ([]byte, error) // user-defined transform function
+	buf  []byte                 // read buffer
+	idx  int                    // read buffer index
+	err  error                  // last error
+	src  io.Reader              // upstream reader, if registered
+	srcs []io.Reader            // upstream readers that the stage closes itself
+	ctx  context.Context        // bound context, if any
+
+	closers []func() error // resources to release on close
+	closed  int32          // transformer has been closed, atomic
+	obs     Observer       // instrumentation hooks, if any
+	pool    *BufferPool    // allocator for NextMessage, if any
+
+	cpfn  func() Checkpoint // stage checkpoint function, if any
+	cp    Checkpoint        // checkpoint prior to the last message
+	cplen int               // size of the last message
+	base  Checkpoint        // checkpoint that the transformer resumed from
+	count int64             // number of messages read
+
+	onskip func(raw []byte, err error) error // error policy handler, if any
+
+	unread [][]byte   // messages pushed back by UnreadMessage, last is next
+	uerr   error      // error that followed a peeked message
+	peeked bool       // unread holds a single message from PeekMessage
+	peekcp Checkpoint // checkpoint prior to the peeked message
+
+	resetfn func(src io.Reader) error // rebinds the stage to a new source
 }
 
 // NewTransformer returns an object that can be used for transforming one
@@ -17,13 +52,235 @@ func NewTransformer(fn func() ([]byte, error)) *Transformer {
 	return &Transformer{tfn: fn}
 }
 
+// NewTransformerContext is like NewTransformer but the fn param receives the
+// context that the transformer is bound to. The context may later be
+// replaced by calling BindContext.
+func NewTransformerContext(ctx context.Context, fn func(ctx context.Context) ([]byte, error)) *Transformer {
+	r := &Transformer{ctx: ctx}
+	r.tfn = func() ([]byte, error) {
+		return fn(r.context())
+	}
+	return r
+}
+
+// Upstream registers the reader that the transformer reads from. This
+// allows for operations such as BindContext to reach every stage of a chain
+// of transformers. Returns the transformer.
+func (r *Transformer) Upstream(src io.Reader) *Transformer {
+	r.src = src
+	return r
+}
+
+// sources registers the readers that a stage reads from in the background or
+// closes by itself. Like Upstream, this allows for BindContext to reach them,
+// but Close leaves closing them to the stage. Returns the transformer.
+func (r *Transformer) sources(srcs ...io.Reader) *Transformer {
+	r.srcs = srcs
+	return r
+}
+
+// BindContext binds the transformer and all of its registered upstream
+// transformers to ctx. Once the context is done, ReadMessage and Read return
+// ctx.Err().
+//
+// When the furthest upstream reader supports read deadlines, such as a
+// net.Conn or an os.File, then its deadline is set to the context deadline,
+// and any blocked read is unblocked when the context is cancelled. This uses
+// a goroutine which exits when the context is done.
+func (r *Transformer) BindContext(ctx context.Context) *Transformer {
+	r.ctx = ctx
+	bindUpstream(ctx, r.src)
+	for _, src := range r.srcs {
+		bindUpstream(ctx, src)
+	}
+	return r
+}
+
+func bindUpstream(ctx context.Context, src io.Reader) {
+	switch src := src.(type) {
+	case *Transformer:
+		src.BindContext(ctx)
+	case interface{ SetReadDeadline(time.Time) error }:
+		if dl, ok := ctx.Deadline(); ok {
+			src.SetReadDeadline(dl)
+		}
+		if done := ctx.Done(); done != nil {
+			go func() {
+				<-done
+				// a deadline in the past unblocks pending reads.
+				src.SetReadDeadline(time.Unix(1, 0))
+			}()
+		}
+	}
+}
+
+// interrupt unblocks the pending reads of the furthest upstream readers of
+// src, by setting their read deadlines in the past. Reports whether all of
+// them support read deadlines.
+func interrupt(src io.Reader) bool {
+	switch src := src.(type) {
+	case *Transformer:
+		ok := src.src != nil || len(src.srcs) > 0
+		if src.src != nil {
+			ok = interrupt(src.src)
+		}
+		for _, s := range src.srcs {
+			ok = interrupt(s) && ok
+		}
+		return ok
+	case interface{ SetReadDeadline(time.Time) error }:
+		return src.SetReadDeadline(time.Unix(1, 0)) == nil
+	}
+	return false
+}
+
+func (r *Transformer) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// contextErr returns the error of the bound context, if it's done.
+func (r *Transformer) contextErr() error {
+	if r.ctx == nil {
+		return nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	if dl, ok := r.ctx.Deadline(); ok && !time.Now().Before(dl) {
+		// the upstream read deadline may fire before the context notices.
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// OnClose registers a function that releases a resource owned by the
+// transformer, such as a decoder. The functions are called by Close in the
+// order that they were registered. Returns the transformer.
+func (r *Transformer) OnClose(fn func() error) *Transformer {
+	r.closers = append(r.closers, fn)
+	return r
+}
+
+// Close conforms to io.Closer. It releases the resources registered with
+// OnClose and then closes the upstream reader, if it was registered with
+// Upstream and implements io.Closer. Calling Close on the outermost
+// transformer of a chain tears down the entire chain.
+//
+// Only the first call has an effect. Close must not be called while another
+// goroutine is reading from the transformer, because the released resources
+// may still be in use, unless the stage documents otherwise, such as
+// Prefetch and Merge. When multiple stages fail to close, the returned error
+// is a CloseError.
+func (r *Transformer) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return nil
+	}
+	var errs CloseError
+	for _, fn := range r.closers {
+		errs = errs.append(fn())
+	}
+	if c, ok := r.src.(io.Closer); ok {
+		errs = errs.append(c.Close())
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// CloseError is returned by Close when more than one resource in a chain
+// fails to close.
+type CloseError []error
+
+func (errs CloseError) append(err error) CloseError {
+	if more, ok := err.(CloseError); ok {
+		return append(errs, more...)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Error conforms to the error interface.
+func (errs CloseError) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual errors.
+func (errs CloseError) Unwrap() []error {
+	return errs
+}
+
 // ReadMessage allows for reading a one transformed message at a time.
+//
+// The returned message is owned by the transformer and is only valid until
+// the next call to ReadMessage, Read, or WriteTo, because transformers may
+// repurpose their own message space. Use ReadMessageCopy or NextMessage for
+// messages that need to outlive the next read.
+//
+// Any data that's waiting in the read buffer from a previous Read is
+// returned first, as the remainder of its message, followed by the messages
+// pushed back by UnreadMessage.
 func (r *Transformer) ReadMessage() ([]byte, error) {
-	return r.tfn()
+	if atomic.LoadInt32(&r.closed) != 0 {
+		return nil, ErrClosed
+	}
+	if err := r.contextErr(); err != nil {
+		return nil, err
+	}
+	if len(r.buf)-r.idx > 0 || r.hasUnread() {
+		return r.readUnread()
+	}
+	var start time.Time
+	if r.obs != nil {
+		start = time.Now()
+	}
+	var msg []byte
+	var err error
+	for {
+		if r.cpfn != nil {
+			r.cp = r.stageCheckpoint()
+		}
+		msg, err = r.tfn()
+		if !r.skipError(&err) {
+			break
+		}
+	}
+	if err != nil {
+		if cerr := r.contextErr(); cerr != nil {
+			msg, err = nil, cerr
+		}
+	}
+	if err == nil || len(msg) > 0 {
+		msg = r.advance(msg)
+	}
+	if r.obs != nil {
+		if err == nil || len(msg) > 0 {
+			r.obs.OnMessage(len(msg), time.Since(start))
+		}
+		if err != nil && err != io.EOF {
+			r.obs.OnError(err)
+		}
+	}
+	return msg, err
 }
 
 // Read conforms to io.Reader
 func (r *Transformer) Read(p []byte) (n int, err error) {
+	if atomic.LoadInt32(&r.closed) != 0 {
+		return 0, ErrClosed
+	}
 	if len(r.buf)-r.idx > 0 {
 		// There's data in the read buffer, return it prior to returning errors
 		// or reading more messages.
@@ -41,14 +298,59 @@ func (r *Transformer) Read(p []byte) (n int, err error) {
 		r.idx = 0         // rewind the read buffer index
 		return n, nil
 	}
-	if r.err != nil {
+	if r.err != nil && !r.hasUnread() {
 		return 0, r.err
 	}
-	var msg []byte
-	msg, r.err = r.ReadMessage()
+	msg, err := r.ReadMessage()
+	if r.err == nil {
+		r.err = err
+	}
 	// We should immediately append the incoming message to the read
 	// buffer to allow for the implemented transformer to repurpose
 	// it's own message space if needed.
 	r.buf = append(r.buf, msg...)
+	if bo, ok := r.obs.(BufferObserver); ok {
+		bo.OnBuffer(len(r.buf) - r.idx)
+	}
 	return r.Read(p)
 }
+
+// WriteTo conforms to io.WriterTo. Each message is written directly to w
+// without first being copied into the read buffer. Any data that was already
+// buffered by a previous Read is written first.
+func (r *Transformer) WriteTo(w io.Writer) (n int64, err error) {
+	if atomic.LoadInt32(&r.closed) != 0 {
+		return 0, ErrClosed
+	}
+	if len(r.buf)-r.idx > 0 {
+		m, err := w.Write(r.buf[r.idx:])
+		n += int64(m)
+		r.idx += m
+		if err != nil {
+			return n, err
+		}
+		r.buf = r.buf[:0]
+		r.idx = 0
+	}
+	for r.err == nil || r.hasUnread() {
+		msg, err := r.ReadMessage()
+		if r.err == nil {
+			r.err = err
+		}
+		if len(msg) > 0 {
+			m, err := w.Write(msg)
+			n += int64(m)
+			if err != nil {
+				if m < len(msg) {
+					// keep the unwritten data for the next read.
+					r.buf = append(r.buf, msg[m:]...)
+				}
+				return n, err
+			}
+		}
+	}
+	if r.err == io.EOF {
+		return n, nil
+	}
+	return n, r.err
+}
@@ -3,11 +3,14 @@ package transform
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net"
 	"regexp"
 	"strings"
 	"testing"
@@ -30,7 +33,7 @@ func Rot13(r io.Reader) *Transformer {
 			}
 		}
 		return buf[:n], nil
-	})
+	}).Upstream(r)
 }
 
 func TestTransformer(t *testing.T) {
@@ -61,6 +64,156 @@ func TestTransformer(t *testing.T) {
 	}
 }
 
+func TestContext(t *testing.T) {
+	// cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewTransformerContext(ctx, func(ctx context.Context) ([]byte, error) {
+		return []byte("hello"), nil
+	})
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(r); err != context.Canceled {
+		t.Fatalf("expected '%v', got '%v'\n", context.Canceled, err)
+	}
+	// blocked upstream reader
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	r = Rot13(Rot13(c1)).BindContext(ctx)
+	go func() {
+		c2.Write([]byte("hello"))
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+	}()
+	data, err := ioutil.ReadAll(r)
+	if err != context.Canceled {
+		t.Fatalf("expected '%v', got '%v'\n", context.Canceled, err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("expected '%v', got '%v'\n", "hello", string(data))
+	}
+	// deadline
+	c1, c2 = net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err = ioutil.ReadAll(Rot13(c1).BindContext(ctx))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected '%v', got '%v'\n", context.DeadlineExceeded, err)
+	}
+}
+
+type closeCounter struct {
+	io.Reader
+	closed int
+	err    error
+}
+
+func (c *closeCounter) Close() error {
+	c.closed++
+	return c.err
+}
+
+func TestClose(t *testing.T) {
+	src := &closeCounter{Reader: bytes.NewBufferString("hello")}
+	var stages int
+	r := Rot13(Rot13(src).OnClose(func() error {
+		stages++
+		return nil
+	}))
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if src.closed != 1 || stages != 1 {
+		t.Fatalf("expected 1 close, got %d and %d", src.closed, stages)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+	}
+	// aggregated errors
+	errA, errB := errors.New("a"), errors.New("b")
+	src = &closeCounter{Reader: bytes.NewBufferString("hello"), err: errA}
+	err := Rot13(Rot13(src).OnClose(func() error { return errB })).Close()
+	var errs CloseError
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0] != errB || errs[1] != errA {
+		t.Fatalf("expected '%v', got '%v'\n", CloseError{errB, errA}, err)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	buf := make([]byte, 100000)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ioutil.ReadAll(Rot13(bytes.NewBuffer(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	n, err := io.Copy(&out, Rot13(bytes.NewBuffer(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(expect)) || !bytes.Equal(out.Bytes(), expect) {
+		t.Fatal("mismatch")
+	}
+	// mixed with read
+	r := Rot13(bytes.NewBuffer(buf))
+	head := make([]byte, 7)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	out.Write(head)
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expect) {
+		t.Fatal("mismatch")
+	}
+}
+
+func benchmarkMessages(n int) *Transformer {
+	msg := make([]byte, 64*1024)
+	return NewTransformer(func() ([]byte, error) {
+		if n == 0 {
+			return nil, io.EOF
+		}
+		n--
+		return msg, nil
+	})
+}
+
+func BenchmarkRead(b *testing.B) {
+	b.SetBytes(64 * 1024)
+	b.ReportAllocs()
+	// hide the io.WriterTo implementation from io.Copy
+	r := struct{ io.Reader }{benchmarkMessages(b.N)}
+	b.ResetTimer()
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	b.SetBytes(64 * 1024)
+	b.ReportAllocs()
+	r := benchmarkMessages(b.N)
+	b.ResetTimer()
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		b.Fatal(err)
+	}
+}
+
 func ExampleTransformer_rot13() {
 	// Rot13 transformation
 	rot13 := func(r io.Reader) *Transformer {
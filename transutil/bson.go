@@ -0,0 +1,111 @@
+package transutil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/tidwall/transform"
+)
+
+// BSONToJSON returns an io.Reader that converts BSON documents into MongoDB
+// Extended JSON messages, one message per document. The input is a stream
+// of concatenated documents, such as a .bson file from mongodump, which are
+// framed by their int32 length prefix.
+//
+// The canonical param selects canonical Extended JSON, which preserves all
+// type information, rather than relaxed Extended JSON, which is easier to
+// read. An invalid document results in a *transform.MessageError.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
+func BSONToJSON(r io.Reader, canonical bool) *transform.Transformer {
+	var doc []byte   // reused
+	var offset int64 // input offset of the next document
+	var br = bufio.NewReader(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		var szb [4]byte
+		if _, err := io.ReadFull(br, szb[:]); err != nil {
+			return nil, err
+		}
+		sz := int64(int32(binary.LittleEndian.Uint32(szb[:])))
+		if sz < 5 {
+			return nil, errors.New("bson: invalid document length")
+		}
+		if sz > transform.MaxFrameSize {
+			return nil, transform.ErrFrameTooLarge
+		}
+		if int64(cap(doc)) < sz {
+			doc = make([]byte, sz)
+		}
+		doc = append(doc[:0], szb[:]...)[:sz]
+		if _, err := io.ReadFull(br, doc[4:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		offset += sz
+		if err := bson.Raw(doc).Validate(); err != nil {
+			return nil, &transform.MessageError{Raw: doc, Err: err}
+		}
+		data, err := bson.MarshalExtJSON(bson.Raw(doc), canonical, false)
+		if err != nil {
+			return nil, &transform.MessageError{Raw: doc, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnCheckpoint(func() transform.Checkpoint {
+		return transform.Checkpoint{Offset: offset}
+	}).OnReset(func(src io.Reader) error {
+		br.Reset(src)
+		offset = 0
+		return nil
+	})
+}
+
+// JSONToBSON returns an io.Reader that converts MongoDB Extended JSON
+// messages, in either canonical or relaxed form, into BSON documents. The
+// order of the fields is preserved, and the output is a stream of
+// concatenated documents, like a .bson file.
+//
+// A message that isn't an object results in a *transform.MessageError.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
+func JSONToBSON(r io.Reader) *transform.Transformer {
+	jr := newJSONReader(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		raw, err := jr.nextRaw()
+		if err != nil {
+			return nil, err
+		}
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(raw, false, &doc); err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(jr.reset)
+}
+
+// BSONToJSONWriter returns an io.WriteCloser that converts BSON documents
+// written to it into Extended JSON messages, and writes the result to w.
+// See BSONToJSON.
+func BSONToJSONWriter(w io.Writer, canonical bool) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return BSONToJSON(r, canonical)
+	})
+}
+
+// JSONToBSONWriter returns an io.WriteCloser that converts Extended JSON
+// messages written to it into BSON documents, and writes the result to w.
+// See JSONToBSON.
+func JSONToBSONWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToBSON(r)
+	})
+}
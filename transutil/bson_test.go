@@ -0,0 +1,107 @@
+package transutil_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/tidwall/transform"
+	"github.com/tidwall/transform/transutil"
+)
+
+func bsonDocs(t *testing.T) []byte {
+	oid, _ := primitive.ObjectIDFromHex("5f1a2b3c4d5e6f7081928374")
+	docs := []bson.D{
+		{{Key: "_id", Value: oid}, {Key: "name", Value: "Jane"}, {Key: "age", Value: int32(46)},
+			{Key: "score", Value: 1.5}, {Key: "visits", Value: int64(7)}},
+		{{Key: "when", Value: primitive.NewDateTimeFromTime(time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC))},
+			{Key: "tags", Value: bson.A{"a", "b"}}, {Key: "nested", Value: bson.D{{Key: "z", Value: true}, {Key: "a", Value: nil}}}},
+	}
+	var stream []byte
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, data...)
+	}
+	return stream
+}
+
+func TestBSONToJSON(t *testing.T) {
+	stream := bsonDocs(t)
+	tests := []struct {
+		canonical bool
+		expect    []string
+	}{
+		{false, []string{
+			`{"_id":{"$oid":"5f1a2b3c4d5e6f7081928374"},"name":"Jane","age":46,"score":1.5,"visits":7}`,
+			`{"when":{"$date":"2013-03-21T20:04:00Z"},"tags":["a","b"],"nested":{"z":true,"a":null}}`,
+		}},
+		{true, []string{
+			`{"_id":{"$oid":"5f1a2b3c4d5e6f7081928374"},"name":"Jane","age":{"$numberInt":"46"},` +
+				`"score":{"$numberDouble":"1.5"},"visits":{"$numberLong":"7"}}`,
+			`{"when":{"$date":{"$numberLong":"1363896240000"}},"tags":["a","b"],"nested":{"z":true,"a":null}}`,
+		}},
+	}
+	for _, tt := range tests {
+		msgs := readMessageStrings(t, transutil.BSONToJSON(bytes.NewReader(stream), tt.canonical))
+		if len(msgs) != len(tt.expect) {
+			t.Fatalf("expected '%v', got '%v'\n", tt.expect, msgs)
+		}
+		for i := range msgs {
+			if msgs[i] != tt.expect[i] {
+				t.Fatalf("expected '%v', got '%v'\n", tt.expect[i], msgs[i])
+			}
+		}
+		// and back, which is exact for canonical mode only.
+		json := []byte(tt.expect[0] + "\n" + tt.expect[1])
+		out := mustReadAll(t, transutil.JSONToBSON(bytes.NewReader(json)))
+		if tt.canonical && !bytes.Equal(out, stream) {
+			t.Fatalf("expected '%x', got '%x'\n", stream, out)
+		}
+	}
+
+	// invalid documents are skipped, the framing stays intact.
+	bad := append([]byte{10, 0, 0, 0, 0x10, 'a', 0, 1, 0, 0}, stream...)
+	r := transutil.BSONToJSON(bytes.NewReader(bad), false)
+	_, err := r.ReadMessage()
+	var merr *transform.MessageError
+	if !errors.As(err, &merr) || len(merr.Raw) != 10 {
+		t.Fatalf("expected '%v', got '%v'\n", "*transform.MessageError", err)
+	}
+	r = transutil.BSONToJSON(bytes.NewReader(bad), false).SkipErrors(nil)
+	if msgs := readMessageStrings(t, r); len(msgs) != 2 {
+		t.Fatalf("expected '%v', got '%v'\n", 2, len(msgs))
+	}
+
+	// truncated streams fail.
+	r = transutil.BSONToJSON(bytes.NewReader(stream[:len(stream)-1]), false)
+	r.ReadMessage()
+	if _, err := r.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected '%v', got '%v'\n", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestBSONWriters(t *testing.T) {
+	stream := bsonDocs(t)
+	var out bytes.Buffer
+	w := transutil.BSONToJSONWriter(transutil.JSONToBSONWriter(&out), true)
+	if _, err := w.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), stream) {
+		t.Fatalf("expected '%x', got '%x'\n", stream, out.Bytes())
+	}
+	testResume(t, stream, func(r io.Reader, cp transform.Checkpoint) *transform.Transformer {
+		return transutil.BSONToJSON(r, true)
+	})
+}
@@ -0,0 +1,291 @@
+package transutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/tidwall/transform"
+)
+
+// cborEncMode encodes deterministically, as described in RFC 8949 section
+// 4.2, and tags timestamps.
+var cborEncMode = func() cbor.EncMode {
+	opts := cbor.CoreDetEncOptions()
+	opts.Time = cbor.TimeRFC3339Nano
+	opts.TimeTag = cbor.EncTagRequired
+	em, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
+
+// CBORToJSON returns an io.Reader that converts CBOR data items into JSON
+// messages, following RFC 8949 section 6.1:
+//
+//   - Byte strings are base64url encoded without padding, or base64 or
+//     base16 encoded when they're within tag 22 or 23.
+//   - Timestamps, tags 0 and 1, are RFC 3339 strings.
+//   - Bignums, tags 2 and 3, are numbers.
+//   - Other tags are replaced by their content.
+//   - NaN, infinity, undefined, and simple values are null.
+//   - Map keys that aren't strings are converted to strings.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
+func CBORToJSON(r io.Reader) *transform.Transformer {
+	dec := cbor.NewDecoder(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(cborToJSON(v, base64URL))
+		if err != nil {
+			raw, _ := cborEncMode.Marshal(v)
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnCheckpoint(func() transform.Checkpoint {
+		return transform.Checkpoint{Offset: int64(dec.NumBytesRead())}
+	}).OnReset(func(src io.Reader) error {
+		dec = cbor.NewDecoder(src)
+		return nil
+	})
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// cborToJSON converts a decoded CBOR value into its JSON equivalent. The
+// enc param encodes byte strings.
+func cborToJSON(v interface{}, enc func([]byte) string) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			var key string
+			if s, ok := k.(string); ok {
+				key = s
+			} else {
+				key = fmt.Sprint(cborToJSON(k, enc))
+			}
+			m[key] = cborToJSON(val, enc)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = cborToJSON(v[i], enc)
+		}
+		return v
+	case []byte:
+		return enc(v)
+	case cbor.ByteString:
+		// byte strings that are map keys, or that are within map keys.
+		return enc([]byte(v))
+	case cbor.Tag:
+		switch v.Number {
+		case 21:
+			enc = base64URL
+		case 22:
+			enc = base64.StdEncoding.EncodeToString
+		case 23:
+			enc = hex.EncodeToString
+		}
+		return cborToJSON(v.Content, enc)
+	case big.Int:
+		return json.Number(v.String())
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case float32:
+		return cborToJSON(float64(v), enc)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+	case cbor.SimpleValue:
+		return nil
+	}
+	return v
+}
+
+// JSONToCBOR returns an io.Reader that converts JSON messages into CBOR data
+// items. Integers are encoded as integers, including those that need a
+// bignum, and all other numbers as the shortest float that preserves them.
+// Maps are encoded deterministically, as described in RFC 8949 section 4.2.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
+func JSONToCBOR(r io.Reader) *transform.Transformer {
+	jr := newJSONReader(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		raw, err := jr.nextRaw()
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		data, err := cborEncMode.Marshal(jsonToCBOR(v))
+		if err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(jr.reset)
+}
+
+// jsonToCBOR converts the numbers of a decoded JSON value into integers and
+// floats.
+func jsonToCBOR(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = jsonToCBOR(val)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonToCBOR(v[i])
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return n
+		}
+		if n, ok := new(big.Int).SetString(string(v), 10); ok {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// MsgPackToCBOR returns an io.Reader that converts MsgPack messages into
+// CBOR data items directly, without converting to JSON in between. Thus
+// binary data and map keys of any type are preserved.
+func MsgPackToCBOR(r io.Reader) *transform.Transformer {
+	dec := msgpack.NewDecoder(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		data, err := cborEncMode.Marshal(v)
+		if err != nil {
+			raw, _ := msgpack.Marshal(&v)
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnReset(dec.Reset)
+}
+
+// CBORToMsgPack returns an io.Reader that converts CBOR data items into
+// MsgPack messages directly, without converting to JSON in between. Tags
+// are replaced by their content, bignums that don't fit into 64 bits become
+// strings, and undefined and simple values become nil.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
+func CBORToMsgPack(r io.Reader) *transform.Transformer {
+	dec := cbor.NewDecoder(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		data, err := msgpack.Marshal(cborToMsgPack(v))
+		if err != nil {
+			raw, _ := cborEncMode.Marshal(v)
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnCheckpoint(func() transform.Checkpoint {
+		return transform.Checkpoint{Offset: int64(dec.NumBytesRead())}
+	}).OnReset(func(src io.Reader) error {
+		dec = cbor.NewDecoder(src)
+		return nil
+	})
+}
+
+// cborToMsgPack converts a decoded CBOR value into its MsgPack equivalent.
+func cborToMsgPack(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, val := range v {
+			if bs, ok := k.(cbor.ByteString); ok {
+				k = string(bs)
+			}
+			m[cborToMsgPack(k)] = cborToMsgPack(val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = cborToMsgPack(v[i])
+		}
+		return v
+	case cbor.Tag:
+		return cborToMsgPack(v.Content)
+	case big.Int:
+		if v.IsInt64() {
+			return v.Int64()
+		}
+		if v.IsUint64() {
+			return v.Uint64()
+		}
+		return v.String()
+	case cbor.SimpleValue:
+		return nil
+	}
+	return v
+}
+
+// CBORToJSONWriter returns an io.WriteCloser that converts CBOR data items
+// written to it into JSON messages, and writes the result to w.
+// See CBORToJSON.
+func CBORToJSONWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return CBORToJSON(r)
+	})
+}
+
+// JSONToCBORWriter returns an io.WriteCloser that converts JSON messages
+// written to it into CBOR data items, and writes the result to w.
+// See JSONToCBOR.
+func JSONToCBORWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToCBOR(r)
+	})
+}
+
+// MsgPackToCBORWriter returns an io.WriteCloser that converts MsgPack
+// messages written to it into CBOR data items, and writes the result to w.
+// See MsgPackToCBOR.
+func MsgPackToCBORWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return MsgPackToCBOR(r)
+	})
+}
+
+// CBORToMsgPackWriter returns an io.WriteCloser that converts CBOR data items
+// written to it into MsgPack messages, and writes the result to w.
+// See CBORToMsgPack.
+func CBORToMsgPackWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return CBORToMsgPack(r)
+	})
+}
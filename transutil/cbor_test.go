@@ -0,0 +1,206 @@
+package transutil_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+
+	"github.com/tidwall/transform/transutil"
+)
+
+// RFC 8949 Appendix A, along with the JSON that each data item converts to,
+// followed by byte strings within map keys.
+var cborVectors = []struct {
+	hex  string
+	json string
+}{
+	{"00", `0`},
+	{"01", `1`},
+	{"0a", `10`},
+	{"17", `23`},
+	{"1818", `24`},
+	{"1819", `25`},
+	{"1864", `100`},
+	{"1903e8", `1000`},
+	{"1a000f4240", `1000000`},
+	{"1b000000e8d4a51000", `1000000000000`},
+	{"1bffffffffffffffff", `18446744073709551615`},
+	{"c249010000000000000000", `18446744073709551616`},
+	{"3bffffffffffffffff", `-18446744073709551616`},
+	{"c349010000000000000000", `-18446744073709551617`},
+	{"20", `-1`},
+	{"29", `-10`},
+	{"3863", `-100`},
+	{"3903e7", `-1000`},
+	{"f90000", `0`},
+	{"f98000", `-0`},
+	{"f93c00", `1`},
+	{"fb3ff199999999999a", `1.1`},
+	{"f93e00", `1.5`},
+	{"f97bff", `65504`},
+	{"fa47c35000", `100000`},
+	{"fa7f7fffff", `3.4028234663852886e+38`},
+	{"fb7e37e43c8800759c", `1e+300`},
+	{"f90001", `5.960464477539063e-8`},
+	{"f90400", `0.00006103515625`},
+	{"f9c400", `-4`},
+	{"fbc010666666666666", `-4.1`},
+	{"f97c00", `null`},
+	{"f97e00", `null`},
+	{"f9fc00", `null`},
+	{"fa7f800000", `null`},
+	{"fa7fc00000", `null`},
+	{"faff800000", `null`},
+	{"fb7ff0000000000000", `null`},
+	{"fb7ff8000000000000", `null`},
+	{"fbfff0000000000000", `null`},
+	{"f4", `false`},
+	{"f5", `true`},
+	{"f6", `null`},
+	{"f7", `null`},
+	{"f0", `null`},
+	{"f8ff", `null`},
+	{"c074323031332d30332d32315432303a30343a30305a", `"2013-03-21T20:04:00Z"`},
+	{"c11a514b67b0", `"2013-03-21T20:04:00Z"`},
+	{"c1fb41d452d9ec200000", `"2013-03-21T20:04:00.5Z"`},
+	{"d74401020304", `"01020304"`},
+	{"d818456449455446", `"ZElFVEY"`},
+	{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", `"http://www.example.com"`},
+	{"40", `""`},
+	{"4401020304", `"AQIDBA"`},
+	{"60", `""`},
+	{"6161", `"a"`},
+	{"6449455446", `"IETF"`},
+	{"62225c", `"\"\\"`},
+	{"62c3bc", `"ü"`},
+	{"63e6b0b4", `"水"`},
+	{"64f0908591", `"𐅑"`},
+	{"80", `[]`},
+	{"83010203", `[1,2,3]`},
+	{"8301820203820405", `[1,[2,3],[4,5]]`},
+	{"98190102030405060708090a0b0c0d0e0f101112131415161718181819",
+		`[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,25]`},
+	{"a0", `{}`},
+	{"a201020304", `{"1":2,"3":4}`},
+	{"a26161016162820203", `{"a":1,"b":[2,3]}`},
+	{"826161a161626163", `["a",{"b":"c"}]`},
+	{"a56161614161626142616361436164614461656145", `{"a":"A","b":"B","c":"C","d":"D","e":"E"}`},
+	{"5f42010243030405ff", `"AQIDBAU"`},
+	{"7f657374726561646d696e67ff", `"streaming"`},
+	{"9fff", `[]`},
+	{"9f018202039f0405ffff", `[1,[2,3],[4,5]]`},
+	{"9f01820203820405ff", `[1,[2,3],[4,5]]`},
+	{"83018202039f0405ff", `[1,[2,3],[4,5]]`},
+	{"83019f0203ff820405", `[1,[2,3],[4,5]]`},
+	{"9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff",
+		`[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,25]`},
+	{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`},
+	{"826161bf61626163ff", `["a",{"b":"c"}]`},
+	{"bf6346756ef563416d7421ff", `{"Amt":-2,"Fun":true}`},
+	{"d6a1d74401020304d5420506", `{"01020304":"BQY"}`},
+	{"d7a1d6420506d5420708", `{"BQY=":"Bwg"}`},
+}
+
+func TestCBORToJSON(t *testing.T) {
+	var stream []byte
+	var expect []string
+	for _, v := range cborVectors {
+		data, err := hex.DecodeString(v.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, data...)
+		expect = append(expect, v.json)
+	}
+	msgs := readMessageStrings(t, transutil.CBORToJSON(bytes.NewReader(stream)))
+	if len(msgs) != len(expect) {
+		t.Fatalf("expected '%v', got '%v'\n", expect, msgs)
+	}
+	for i := range msgs {
+		if msgs[i] != expect[i] {
+			t.Fatalf("%s: expected '%v', got '%v'\n", cborVectors[i].hex, expect[i], msgs[i])
+		}
+	}
+}
+
+func TestJSONToCBOR(t *testing.T) {
+	tests := []struct {
+		json string
+		hex  string
+	}{
+		{`0`, "00"},
+		{`1000000000000`, "1b000000e8d4a51000"},
+		{`18446744073709551615`, "1bffffffffffffffff"},
+		{`18446744073709551616`, "c249010000000000000000"},
+		{`-18446744073709551617`, "c349010000000000000000"},
+		{`-1000`, "3903e7"},
+		{`1.5`, "f93e00"},
+		{`1.1`, "fb3ff199999999999a"},
+		{`1e+300`, "fb7e37e43c8800759c"},
+		{`"ü"`, "62c3bc"},
+		{`[1,[2,3],[4,5]]`, "8301820203820405"},
+		// deterministic key order
+		{`{"b":[2,3],"a":1}`, "a26161016162820203"},
+		{`null`, "f6"},
+	}
+	for _, tt := range tests {
+		out := mustReadAll(t, transutil.JSONToCBOR(bytes.NewBufferString(tt.json)))
+		if hex.EncodeToString(out) != tt.hex {
+			t.Fatalf("%s: expected '%v', got '%v'\n", tt.json, tt.hex, hex.EncodeToString(out))
+		}
+	}
+	// and back
+	json := `{"name":{"first":"Jane","last":"Prichard"},"age":46,"friends":["Charlie","Vihaan","Carol"]}`
+	var out bytes.Buffer
+	w := transutil.JSONToCBORWriter(transutil.CBORToJSONWriter(&out))
+	if _, err := w.Write([]byte(json)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !matchingJSON(json, out.String()) {
+		t.Fatal("json mismatch")
+	}
+}
+
+func TestMsgPackAndCBOR(t *testing.T) {
+	v := map[interface{}]interface{}{
+		"bin":    []byte{1, 2, 3, 4},
+		int64(7): "seven",
+		"list":   []interface{}{int64(1), "two", 3.5, nil, true},
+	}
+	data, err := msgpack.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := mustReadAll(t, transutil.MsgPackToCBOR(bytes.NewReader(data)))
+	// binary data is kept as a byte string.
+	msgs := readMessageStrings(t, transutil.CBORToJSON(bytes.NewReader(cb)))
+	expect := `{"7":"seven","bin":"AQIDBA","list":[1,"two",3.5,null,true]}`
+	if len(msgs) != 1 || msgs[0] != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, msgs)
+	}
+	mp := mustReadAll(t, transutil.CBORToMsgPack(bytes.NewReader(cb)))
+	var back map[interface{}]interface{}
+	if err := msgpack.Unmarshal(mp, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back["bin"].([]byte), []byte{1, 2, 3, 4}) || back[uint64(7)] != "seven" {
+		t.Fatalf("unexpected values %v", back)
+	}
+
+	// bignums that don't fit into 64 bits become strings.
+	data, _ = hex.DecodeString("c349010000000000000000c2420100")
+	mp = mustReadAll(t, transutil.CBORToMsgPack(bytes.NewReader(data)))
+	dec := msgpack.NewDecoder(bytes.NewReader(mp))
+	var big, small interface{}
+	if err := dec.Decode(&big); err != nil || big != "-18446744073709551617" {
+		t.Fatalf("expected '%v', got '%v'\n", "-18446744073709551617", big)
+	}
+	if err := dec.Decode(&small); err != nil || small != uint64(256) {
+		t.Fatalf("expected '%v', got '%T %v'\n", 256, small, small)
+	}
+}
@@ -0,0 +1,363 @@
+package transutil
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/tidwall/transform"
+)
+
+// CSVOptions are the options for CSVToJSON and JSONToCSV.
+type CSVOptions struct {
+	// Comma is the field delimiter. The default is ','. Use '\t' for TSV.
+	Comma rune
+	// NoHeader is set when the CSV has no header row. CSVToJSON emits each
+	// record as an array rather than an object keyed by the header, and
+	// JSONToCSV doesn't write a header row.
+	NoHeader bool
+	// LazyQuotes allows for quotes in unquoted fields and for unescaped
+	// quotes in quoted fields, see csv.Reader. CSVToJSON only.
+	LazyQuotes bool
+	// InferTypes converts fields that look like numbers, true, false, and
+	// null into their JSON equivalents, and empty fields into null. Otherwise
+	// all fields are strings. CSVToJSON only.
+	InferTypes bool
+	// Columns is the list of columns that JSONToCSV writes, in order. When
+	// not set the columns are discovered from the first Sample records, in
+	// order of appearance.
+	Columns []string
+	// Sample is the number of records that JSONToCSV discovers the columns
+	// from. The default is 100.
+	Sample int
+	// UseCRLF makes JSONToCSV end rows with \r\n rather than \n.
+	UseCRLF bool
+}
+
+func csvOptions(opts *CSVOptions) CSVOptions {
+	var o CSVOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Comma == 0 {
+		o.Comma = ','
+	}
+	if o.Sample <= 0 {
+		o.Sample = 100
+	}
+	return o
+}
+
+// CSVToJSON returns an io.Reader that converts CSV records into JSON
+// messages, one message per record. By default the first row is the header,
+// and each record is an object with the header fields as keys, in the same
+// order. Passing nil for opts uses the default options.
+//
+// A malformed record, such as one with the wrong number of fields, results
+// in a *transform.MessageError with the record's fields as its raw input.
+func CSVToJSON(r io.Reader, opts *CSVOptions) *transform.Transformer {
+	o := csvOptions(opts)
+	var cr *csv.Reader
+	var header []string
+	var buf []byte // reused
+	reset := func(src io.Reader) error {
+		cr = csv.NewReader(src)
+		cr.Comma = o.Comma
+		cr.LazyQuotes = o.LazyQuotes
+		cr.ReuseRecord = true
+		header = nil
+		return nil
+	}
+	reset(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		record, err := cr.Read()
+		if err != nil {
+			if perr, ok := err.(*csv.ParseError); ok {
+				return nil, &transform.MessageError{Raw: encodeCSV(record, o.Comma), Err: perr}
+			}
+			return nil, err
+		}
+		if !o.NoHeader && header == nil {
+			header = append([]string(nil), record...)
+			if record, err = cr.Read(); err != nil {
+				if perr, ok := err.(*csv.ParseError); ok {
+					return nil, &transform.MessageError{Raw: encodeCSV(record, o.Comma), Err: perr}
+				}
+				return nil, err
+			}
+		}
+		open, end := byte('['), byte(']')
+		if !o.NoHeader {
+			open, end = '{', '}'
+		}
+		buf = append(buf[:0], open)
+		for i, field := range record {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if !o.NoHeader {
+				buf = appendJSONString(buf, header[i])
+				buf = append(buf, ':')
+			}
+			buf = appendCSVField(buf, field, o.InferTypes)
+		}
+		return append(buf, end), nil
+	}).Upstream(r).OnReset(reset)
+}
+
+// appendCSVField appends a field as a JSON value.
+func appendCSVField(buf []byte, field string, infer bool) []byte {
+	if infer {
+		switch field {
+		case "", "null":
+			return append(buf, "null"...)
+		case "true", "false":
+			return append(buf, field...)
+		}
+		if (field[0] == '-' || (field[0] >= '0' && field[0] <= '9')) &&
+			json.Valid([]byte(field)) {
+			return append(buf, field...)
+		}
+	}
+	return appendJSONString(buf, field)
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	data, _ := json.Marshal(s)
+	return append(buf, data...)
+}
+
+// encodeCSV returns a record as a CSV row.
+func encodeCSV(record []string, comma rune) []byte {
+	if record == nil {
+		return nil
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = comma
+	w.Write(record)
+	w.Flush()
+	return bytes.TrimRight(b.Bytes(), "\n")
+}
+
+// csvCell is a flattened JSON value.
+type csvCell struct {
+	column string // dotted path of the value
+	value  string // the value formatted for CSV
+}
+
+// csvRecord is a flattened JSON record.
+type csvRecord struct {
+	cells []csvCell
+	array bool // the record is an array, its cells have no column
+}
+
+// JSONToCSV returns an io.Reader that converts JSON records into CSV rows,
+// one message per row, with the header row first. Each record is an object,
+// where nested objects are flattened into columns with dotted keys, such as
+// "name.first". Arrays are written as JSON text, and null as an empty field.
+// Records that are arrays are written as is, one field per element, and an
+// empty array results in a *transform.MessageError. Passing nil for opts
+// uses the default options.
+//
+// Columns that aren't discovered are left out, and missing columns are
+// written as empty fields.
+func JSONToCSV(r io.Reader, opts *CSVOptions) *transform.Transformer {
+	o := csvOptions(opts)
+	var jr = newJSONReader(r)
+	var columns map[string]int // column indexes, nil until discovered
+	var header []string        // columns in order
+	var pending []csvRecord    // sampled records
+	var serr error             // stream error that ended the sampling
+	var headerDone bool        // header row was emitted
+	var row []string           // reused
+	var b bytes.Buffer         // reused
+	var w = csv.NewWriter(&b)
+	w.Comma = o.Comma
+	w.UseCRLF = o.UseCRLF
+	write := func(record []string) ([]byte, error) {
+		b.Reset()
+		w.Write(record)
+		w.Flush()
+		return b.Bytes(), w.Error()
+	}
+	setColumns := func(names []string) {
+		columns = make(map[string]int)
+		header = header[:0]
+		for _, name := range names {
+			if _, ok := columns[name]; !ok {
+				columns[name] = len(header)
+				header = append(header, name)
+			}
+		}
+	}
+	reset := func(src io.Reader) error {
+		jr.reset(src)
+		columns, pending, serr, headerDone = nil, nil, nil, false
+		if o.Columns != nil {
+			setColumns(o.Columns)
+		}
+		return nil
+	}
+	reset(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		if columns == nil {
+			// sample the first records for their columns.
+			for len(pending) < o.Sample {
+				rec, err := nextCSVRecord(jr)
+				if err != nil {
+					if _, ok := err.(*transform.MessageError); ok {
+						return nil, err
+					}
+					serr = err
+					break
+				}
+				pending = append(pending, rec)
+			}
+			var names []string
+			for _, rec := range pending {
+				if !rec.array {
+					for _, cell := range rec.cells {
+						names = append(names, cell.column)
+					}
+				}
+			}
+			setColumns(names)
+		}
+		if !headerDone {
+			headerDone = true
+			if !o.NoHeader && len(header) > 0 {
+				return write(header)
+			}
+		}
+		var rec csvRecord
+		if len(pending) > 0 {
+			rec = pending[0]
+			pending = pending[1:]
+		} else if serr != nil {
+			return nil, serr
+		} else {
+			var err error
+			if rec, err = nextCSVRecord(jr); err != nil {
+				return nil, err
+			}
+		}
+		row = row[:0]
+		if rec.array {
+			for _, cell := range rec.cells {
+				row = append(row, cell.value)
+			}
+			return write(row)
+		}
+		for range header {
+			row = append(row, "")
+		}
+		for _, cell := range rec.cells {
+			if i, ok := columns[cell.column]; ok {
+				row[i] = cell.value
+			}
+		}
+		return write(row)
+	}).Upstream(r).OnReset(reset)
+}
+
+// nextCSVRecord reads the next JSON record and flattens it into cells.
+func nextCSVRecord(jr *jsonReader) (csvRecord, error) {
+	raw, err := jr.nextRaw()
+	if err != nil {
+		return csvRecord{}, err
+	}
+	var rec csvRecord
+	switch firstByte(raw) {
+	case '{':
+		rec.cells, err = flattenJSON(nil, "", raw)
+	case '[':
+		rec.array = true
+		var elems []json.RawMessage
+		if err = json.Unmarshal(raw, &elems); err == nil && len(elems) == 0 {
+			// an empty row is a blank line, which CSV readers skip.
+			err = errors.New("record is an empty array")
+		}
+		for _, elem := range elems {
+			rec.cells = append(rec.cells, csvCell{value: csvValue(elem)})
+		}
+	default:
+		err = errors.New("record is not an object or an array")
+	}
+	if err != nil {
+		return csvRecord{}, &transform.MessageError{Raw: raw, Err: err}
+	}
+	return rec, nil
+}
+
+// flattenJSON appends the values of a JSON object to cells, in document
+// order. Nested objects are flattened using dotted keys.
+func flattenJSON(cells []csvCell, prefix string, raw json.RawMessage) ([]csvCell, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		column := prefix + tok.(string)
+		if firstByte(v) == '{' {
+			if cells, err = flattenJSON(cells, column+".", v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		cells = append(cells, csvCell{column: column, value: csvValue(v)})
+	}
+	return cells, nil
+}
+
+// csvValue formats a JSON value for CSV.
+func csvValue(v json.RawMessage) string {
+	switch firstByte(v) {
+	case '"':
+		var s string
+		json.Unmarshal(v, &s)
+		return s
+	case 'n':
+		return ""
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, v); err != nil {
+		return string(v)
+	}
+	return b.String()
+}
+
+func firstByte(v []byte) byte {
+	v = bytes.TrimSpace(v)
+	if len(v) == 0 {
+		return 0
+	}
+	return v[0]
+}
+
+// CSVToJSONWriter returns an io.WriteCloser that converts CSV records
+// written to it into JSON messages, and writes the result to w.
+// See CSVToJSON.
+func CSVToJSONWriter(w io.Writer, opts *CSVOptions) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return CSVToJSON(r, opts)
+	})
+}
+
+// JSONToCSVWriter returns an io.WriteCloser that converts JSON records
+// written to it into CSV rows, and writes the result to w. See JSONToCSV.
+func JSONToCSVWriter(w io.Writer, opts *CSVOptions) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToCSV(r, opts)
+	})
+}
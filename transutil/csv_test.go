@@ -0,0 +1,139 @@
+package transutil_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/transform"
+	"github.com/tidwall/transform/transutil"
+)
+
+func readMessageStrings(t *testing.T, r *transform.Transformer) []string {
+	var msgs []string
+	for {
+		msg, err := r.ReadMessageCopy()
+		if len(msg) > 0 {
+			msgs = append(msgs, string(msg))
+		}
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			return msgs
+		}
+	}
+}
+
+func TestCSVToJSON(t *testing.T) {
+	input := "name,age,admin,note\nJane,46,true,\n\"Prichard, C\",007,false,\"said \"\"hi\"\"\"\n"
+	tests := []struct {
+		opts   *transutil.CSVOptions
+		input  string
+		expect []string
+	}{
+		{nil, input, []string{
+			`{"name":"Jane","age":"46","admin":"true","note":""}`,
+			`{"name":"Prichard, C","age":"007","admin":"false","note":"said \"hi\""}`,
+		}},
+		{&transutil.CSVOptions{InferTypes: true}, input, []string{
+			`{"name":"Jane","age":46,"admin":true,"note":null}`,
+			`{"name":"Prichard, C","age":"007","admin":false,"note":"said \"hi\""}`,
+		}},
+		{&transutil.CSVOptions{NoHeader: true, Comma: '\t', InferTypes: true},
+			"a\t1.5\tnull\nb\t-2e3\tx\n", []string{
+				`["a",1.5,null]`,
+				`["b",-2e3,"x"]`,
+			}},
+	}
+	for i, tt := range tests {
+		msgs := readMessageStrings(t, transutil.CSVToJSON(strings.NewReader(tt.input), tt.opts))
+		if strings.Join(msgs, "\n") != strings.Join(tt.expect, "\n") {
+			t.Fatalf("%d: expected '%v', got '%v'\n", i, tt.expect, msgs)
+		}
+	}
+
+	// records with the wrong number of fields can be skipped.
+	var dead bytes.Buffer
+	r := transutil.CSVToJSON(strings.NewReader("a,b\n1,2\n3\n4,5\n"), nil).DeadLetter(&dead, nil)
+	msgs := readMessageStrings(t, r)
+	if strings.Join(msgs, " ") != `{"a":"1","b":"2"} {"a":"4","b":"5"}` || dead.String() != "3\n" {
+		t.Fatalf("unexpected output %v %q", msgs, dead.String())
+	}
+}
+
+func TestJSONToCSV(t *testing.T) {
+	input := `{"name":{"first":"Jane","last":"Prichard"},"age":46,"tags":["a","b"]}
+{"name":{"first":"Carol"},"age":null,"admin":true,"note":"said \"hi\", twice"}
+{"age":3,"extra":1}
+`
+	tests := []struct {
+		opts   *transutil.CSVOptions
+		expect string
+	}{
+		{nil, "name.first,name.last,age,tags,admin,note,extra\n" +
+			"Jane,Prichard,46,\"[\"\"a\"\",\"\"b\"\"]\",,,\n" +
+			"Carol,,,,true,\"said \"\"hi\"\", twice\",\n" +
+			",,3,,,,1\n"},
+		{&transutil.CSVOptions{Sample: 1, Comma: '\t'}, "name.first\tname.last\tage\ttags\n" +
+			"Jane\tPrichard\t46\t\"[\"\"a\"\",\"\"b\"\"]\"\n" +
+			"Carol\t\t\t\n" +
+			"\t\t3\t\n"},
+		{&transutil.CSVOptions{Columns: []string{"age", "name.first"}, NoHeader: true, UseCRLF: true},
+			"46,Jane\r\n,Carol\r\n3,\r\n"},
+	}
+	for i, tt := range tests {
+		out := string(mustReadAll(t, transutil.JSONToCSV(strings.NewReader(input), tt.opts)))
+		if out != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'\n", i, tt.expect, out)
+		}
+	}
+
+	// array records, and a round trip.
+	csv := "a,b\n1,x\n2,y\n"
+	r := transutil.JSONToCSV(transutil.CSVToJSON(strings.NewReader(csv), &transutil.CSVOptions{NoHeader: true}),
+		&transutil.CSVOptions{NoHeader: true})
+	if out := string(mustReadAll(t, r)); out != csv {
+		t.Fatalf("expected '%v', got '%v'\n", csv, out)
+	}
+
+	// the same round trip through writers.
+	var out bytes.Buffer
+	w := transutil.CSVToJSONWriter(transutil.JSONToCSVWriter(&out, nil), nil)
+	if _, err := w.Write([]byte(csv)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != csv {
+		t.Fatalf("expected '%v', got '%v'\n", csv, out.String())
+	}
+
+	// an empty key is a column of its own, not an array record.
+	r = transutil.JSONToCSV(strings.NewReader(`{"":1,"a":2}
+{"a":3}
+`), nil)
+	if out := string(mustReadAll(t, r)); out != ",a\n1,2\n,3\n" {
+		t.Fatalf("expected '%v', got '%v'\n", ",a\n1,2\n,3\n", out)
+	}
+
+	// empty arrays are rejected, and may be skipped.
+	_, err := transutil.JSONToCSV(strings.NewReader(`[1,2] [] [3,4]`), nil).ReadMessage()
+	var merr *transform.MessageError
+	if !errors.As(err, &merr) || string(merr.Raw) != "[]" {
+		t.Fatalf("expected '%v', got '%v'\n", "[]", err)
+	}
+	r = transutil.JSONToCSV(strings.NewReader(`[1,2] [] [3,4]`), nil).SkipErrors(nil)
+	if out := string(mustReadAll(t, r)); out != "1,2\n3,4\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "1,2\n3,4\n", out)
+	}
+
+	// scalar records aren't supported.
+	_, err = transutil.JSONToCSV(strings.NewReader(`{"a":1} 17`), nil).ReadMessage()
+	if !errors.As(err, &merr) || string(merr.Raw) != "17" {
+		t.Fatalf("expected '%v', got '%v'\n", "17", err)
+	}
+}
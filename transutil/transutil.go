@@ -1,6 +1,7 @@
 // Package transutil provides a set of example utilites for converting between
 // common data formats using an io.Reader. Currently supporte are JSON,
-// MsgPack, and ProtoBuf. Also provided is Gzipper/Gunzipper readers.
+// MsgPack, ProtoBuf, CSV, YAML, XML, CBOR, and BSON. Also provided is
+// Gzipper/Gunzipper readers.
 package transutil
 
 import (
@@ -9,6 +10,7 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 
@@ -21,28 +23,32 @@ import (
 
 // JSONToPrettyJSON returns an io.Reader that converts JSON messages
 // by making them more human readable using indentation and linebreaks.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
 func JSONToPrettyJSON(r io.Reader) *transform.Transformer {
-	dec := json.NewDecoder(r)
+	jr := newJSONReader(r)
 	return transform.NewTransformer(func() ([]byte, error) {
-		var v interface{}
-		if err := dec.Decode(&v); err != nil {
+		v, err := jr.next()
+		if err != nil {
 			return nil, err
 		}
 		return json.MarshalIndent(&v, "", "  ")
-	})
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(jr.reset)
 }
 
 // JSONToUglyJSON returns an io.Reader that converts JSON messages
 // by removing all unneeded whitespace.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
 func JSONToUglyJSON(r io.Reader) *transform.Transformer {
-	dec := json.NewDecoder(r)
+	jr := newJSONReader(r)
 	return transform.NewTransformer(func() ([]byte, error) {
-		var v interface{}
-		if err := dec.Decode(&v); err != nil {
+		v, err := jr.next()
+		if err != nil {
 			return nil, err
 		}
 		return json.Marshal(&v)
-	})
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(jr.reset)
 }
 
 // JSONToProtoBuf returns an io.Reader that converts JSON messages
@@ -56,25 +62,34 @@ func JSONToUglyJSON(r io.Reader) *transform.Transformer {
 // The multimessage param is used for sending multiple messages over the same
 // stream. When this param is set, additional varint bytes are added to
 // the beginning of each message. Otherwise only one message is allowed.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
 func JSONToProtoBuf(r io.Reader, pb proto.Message, multimessage bool) *transform.Transformer {
 	var count int
-	var dec = json.NewDecoder(r)
+	var jr = newJSONReader(r)
 	return transform.NewTransformer(func() ([]byte, error) {
-		if err := jsonpb.UnmarshalNext(dec, pb); err != nil {
+		raw, err := jr.nextRaw()
+		if err != nil {
 			return nil, err
 		}
 		if count > 0 && !multimessage {
 			return nil, errors.New("not a multimessage stream")
 		}
+		if err := jsonpb.Unmarshal(bytes.NewReader(raw), pb); err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
 		data, err := proto.Marshal(pb)
 		if err != nil {
-			return nil, err
+			return nil, &transform.MessageError{Raw: raw, Err: err}
 		}
 		if multimessage {
 			data = append(proto.EncodeVarint(uint64(len(data))), data...)
 		}
 		count++
 		return data, err
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(func(src io.Reader) error {
+		count = 0
+		return jr.reset(src)
 	})
 }
 
@@ -87,26 +102,37 @@ func JSONToProtoBuf(r io.Reader, pb proto.Message, multimessage bool) *transform
 // calling this function.
 //
 // The multimessage param is used for sending multiple messages over the same
-// stream. When this param is set, additional varint bytes are added to
+// stream. When this param is set, additional varint bytes are expected at
 // the beginning of each message. Otherwise only one message is allowed.
+//
+// In multimessage mode the returned transformer supports checkpoints, see
+// transform.Resume.
 func ProtoBufToJSON(r io.Reader, pb proto.Message, multimessage bool) *transform.Transformer {
 	if !multimessage {
 		return transform.NewTransformer(func() ([]byte, error) {
-			if data, err := ioutil.ReadAll(r); err != nil {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
 				return nil, err
 			} else if len(data) == 0 {
 				return nil, io.EOF
 			} else if err = proto.Unmarshal(data, pb); err != nil {
-				return nil, err
+				return nil, &transform.MessageError{Raw: data, Err: err}
 			}
 			// transform the pb to json. let's use the default options that
 			// golang/protobuf recommends.
 			str, err := (&jsonpb.Marshaler{}).MarshalToString(pb)
-			return []byte(str), err
+			if err != nil {
+				return nil, &transform.MessageError{Raw: data, Err: err}
+			}
+			return []byte(str), nil
+		}).Upstream(r).OnReset(func(src io.Reader) error {
+			r = src
+			return nil
 		})
 	}
-	var szb []byte // reused
-	var msg []byte // reused
+	var szb []byte    // reused
+	var msg []byte    // reused
+	var offset uint64 // input offset of the next message
 	var br = bufio.NewReader(r)
 	return transform.NewTransformer(func() ([]byte, error) {
 		var err error
@@ -144,14 +170,24 @@ func ProtoBufToJSON(r io.Reader, pb proto.Message, multimessage bool) *transform
 		if _, err := io.ReadFull(br, msg[:sz]); err != nil {
 			return nil, err
 		}
+		offset += uint64(len(szb)) + sz
 		// unmarshal the message
 		if err := proto.Unmarshal(msg[:sz], pb); err != nil {
-			return nil, err
+			return nil, &transform.MessageError{Raw: msg[:sz], Err: err}
 		}
 		// transform the pb to json. let's use the default options that
 		// golang/protobuf recommends.
 		str, err := (&jsonpb.Marshaler{}).MarshalToString(pb)
-		return []byte(str), err
+		if err != nil {
+			return nil, &transform.MessageError{Raw: msg[:sz], Err: err}
+		}
+		return []byte(str), nil
+	}).Upstream(r).OnCheckpoint(func() transform.Checkpoint {
+		return transform.Checkpoint{Offset: int64(offset)}
+	}).OnReset(func(src io.Reader) error {
+		br.Reset(src)
+		offset = 0
+		return nil
 	})
 }
 
@@ -169,8 +205,155 @@ func MsgPackToJSON(r io.Reader) *transform.Transformer {
 		// `map[interface{}]interface{}`.
 		// No sweat though, we'll just do a little recursive translation.
 		v = remapKeysToStrings(v)
-		return json.Marshal(&v)
-	})
+		data, err := json.Marshal(&v)
+		if err != nil {
+			// the value is valid MsgPack that has no JSON equivalent.
+			raw, _ := msgpack.Marshal(&v)
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnReset(dec.Reset)
+}
+
+// jsonReader reads a stream of JSON values. When a malformed value is
+// encountered, it's skipped up to the end of its line and reported as a
+// *transform.MessageError, allowing for newline-delimited streams to
+// recover from bad records. A value that spans lines, such as pretty-printed
+// JSON, is skipped up to the line where it ends, see skipMalformed.
+type jsonReader struct {
+	r    io.Reader     // remaining input
+	dec  *json.Decoder // decoder of the remaining input
+	base int64         // input offset of the decoder
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	return &jsonReader{r: r, dec: json.NewDecoder(r)}
+}
+
+// nextRaw returns the next JSON value.
+func (jr *jsonReader) nextRaw() (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := jr.dec.Decode(&raw)
+	if _, ok := err.(*json.SyntaxError); !ok {
+		return raw, err
+	}
+	// The decoder's buffer starts with the whitespace preceding the
+	// malformed value. Skip the value and start over with a fresh decoder.
+	bad, r, rerr := skipMalformed(io.MultiReader(jr.dec.Buffered(), jr.r))
+	if rerr != nil {
+		return nil, rerr
+	}
+	jr.base += jr.dec.InputOffset() + int64(len(bad))
+	jr.r = r
+	jr.dec = json.NewDecoder(r)
+	return nil, &transform.MessageError{Raw: bytes.TrimSpace(bad), Err: err}
+}
+
+// skipMalformed reads a malformed JSON value from r, along with the rest of
+// the line where the value ends. The brackets outside of strings are
+// counted to find that line, which is the first line that ends with all of
+// them closed. A line that starts with '{' or '[' while brackets are open
+// starts the next value instead, unless it follows a ',', ':' or an opening
+// bracket, because it's then missing a comma and the malformed value was
+// cut short, such as a truncated record of a newline-delimited stream.
+// Returns the skipped input and the reader for the remaining input.
+func skipMalformed(r io.Reader) ([]byte, io.Reader, error) {
+	var bad []byte
+	var depth int      // open brackets
+	var str, esc bool  // in a string, following a backslash
+	var last byte      // last byte outside of strings, other than whitespace
+	var lineStart bool // at the start of a line while brackets are open
+	var b [1]byte
+	for {
+		n, err := r.Read(b[:])
+		if n == 0 {
+			if err == io.EOF {
+				return bad, r, nil
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		c := b[0]
+		if lineStart {
+			if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+				bad = append(bad, c)
+				continue
+			}
+			lineStart = false
+			if (c == '{' || c == '[') && last != ',' && last != ':' && last != '{' && last != '[' {
+				// the next value, put it back.
+				return bad, io.MultiReader(bytes.NewReader([]byte{c}), r), nil
+			}
+		}
+		bad = append(bad, c)
+		switch {
+		case c == '\n':
+			// strings don't span lines.
+			str, esc = false, false
+			if depth > 0 {
+				lineStart = true
+			} else if len(bytes.TrimSpace(bad)) > 0 {
+				return bad, r, nil
+			}
+		case str:
+			if esc {
+				esc = false
+			} else if c == '\\' {
+				esc = true
+			} else if c == '"' {
+				str = false
+				last = c
+			}
+		case c == '"':
+			str = true
+		case c == '{' || c == '[':
+			depth++
+			last = c
+		case c == '}' || c == ']':
+			if depth > 0 {
+				depth--
+			}
+			last = c
+		case c != ' ' && c != '\t' && c != '\r':
+			last = c
+		}
+	}
+}
+
+// next returns the next decoded JSON value.
+func (jr *jsonReader) next() (interface{}, error) {
+	raw, err := jr.nextRaw()
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, &transform.MessageError{Raw: raw, Err: err}
+	}
+	return v, nil
+}
+
+// reset conforms to the transform.Transformer.OnReset function.
+func (jr *jsonReader) reset(src io.Reader) error {
+	jr.r, jr.dec, jr.base = src, json.NewDecoder(src), 0
+	return nil
+}
+
+// checkpoint conforms to the transform.Transformer.OnCheckpoint function.
+func (jr *jsonReader) checkpoint() transform.Checkpoint {
+	offset := jr.base + jr.dec.InputOffset()
+	// skip the buffered whitespace that precedes the next value.
+	buf := jr.dec.Buffered().(io.ByteReader)
+	for {
+		c, err := buf.ReadByte()
+		if err != nil || (c != ' ' && c != '\t' && c != '\r' && c != '\n') {
+			break
+		}
+		offset++
+	}
+	return transform.Checkpoint{Offset: offset}
 }
 
 func remapKeysToStrings(v interface{}) interface{} {
@@ -179,25 +362,43 @@ func remapKeysToStrings(v interface{}) interface{} {
 		// create a new map with a string key
 		nv := make(map[string]interface{})
 		for k, v := range iv {
-			// translate nested values
-			nv[k.(string)] = remapKeysToStrings(v)
+			// translate nested values, non-string keys are formatted.
+			ks, ok := k.(string)
+			if !ok {
+				ks = fmt.Sprint(k)
+			}
+			nv[ks] = remapKeysToStrings(v)
 		}
 		return nv
 	}
+	if mv, ok := v.(map[string]interface{}); ok {
+		for k, v := range mv {
+			mv[k] = remapKeysToStrings(v)
+		}
+		return mv
+	}
+	if av, ok := v.([]interface{}); ok {
+		// maps may be nested in arrays too.
+		for i := range av {
+			av[i] = remapKeysToStrings(av[i])
+		}
+	}
 	return v
 }
 
 // JSONToMsgPack returns an io.Reader that converts JSON messages
 // into MsgPack messages.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
 func JSONToMsgPack(r io.Reader) *transform.Transformer {
-	dec := json.NewDecoder(r)
+	jr := newJSONReader(r)
 	return transform.NewTransformer(func() ([]byte, error) {
-		var v interface{}
-		if err := dec.Decode(&v); err != nil {
+		v, err := jr.next()
+		if err != nil {
 			return nil, err
 		}
 		return msgpack.Marshal(&v)
-	})
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(jr.reset)
 }
 
 // Gzipper will gzip the input reader
@@ -223,26 +424,121 @@ func Gzipper(r io.Reader) *transform.Transformer {
 			}
 		}
 		return b.Bytes(), nil
+	}).Upstream(r).OnReset(func(src io.Reader) error {
+		r = src
+		b.Reset()
+		w.Reset(&b)
+		return nil
 	})
 }
 
 // Gunzipper will gunzip the input reader
 func Gunzipper(r io.Reader) *transform.Transformer {
 	var zr *gzip.Reader
+	var zok bool // zr is reading from r
 	var rbuf = make([]byte, 4096)
 	return transform.NewTransformer(func() ([]byte, error) {
 		var err error
-		if zr == nil {
-			zr, err = gzip.NewReader(r)
+		if !zok {
+			if zr == nil {
+				zr, err = gzip.NewReader(r)
+			} else {
+				err = zr.Reset(r)
+			}
 			if err != nil {
 				return nil, err
 			}
+			zok = true
 		}
 		n, err := zr.Read(rbuf)
 		if err != nil {
 			zr.Close()
-			return nil, err
 		}
-		return rbuf[:n], nil
+		// the final chunk may arrive along with the io.EOF
+		return rbuf[:n], err
+	}).Upstream(r).OnClose(func() error {
+		if zr == nil {
+			return nil
+		}
+		return zr.Close()
+	}).OnReset(func(src io.Reader) error {
+		// the gzip header is read from src on the first read.
+		r, zok = src, false
+		return nil
+	})
+}
+
+// JSONToPrettyJSONWriter returns an io.WriteCloser that converts JSON
+// messages written to it by making them more human readable, and writes the
+// result to w. See JSONToPrettyJSON.
+func JSONToPrettyJSONWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToPrettyJSON(r)
+	})
+}
+
+// JSONToUglyJSONWriter returns an io.WriteCloser that converts JSON messages
+// written to it by removing all unneeded whitespace, and writes the result
+// to w. See JSONToUglyJSON.
+func JSONToUglyJSONWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToUglyJSON(r)
+	})
+}
+
+// JSONToProtoBufWriter returns an io.WriteCloser that converts JSON messages
+// written to it into Protocol Buffers, and writes the result to w.
+// See JSONToProtoBuf.
+func JSONToProtoBufWriter(w io.Writer, pb proto.Message, multimessage bool) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToProtoBuf(r, pb, multimessage)
+	})
+}
+
+// ProtoBufToJSONWriter returns an io.WriteCloser that converts Proto Buffer
+// messages written to it into JSON, and writes the result to w.
+// See ProtoBufToJSON.
+func ProtoBufToJSONWriter(w io.Writer, pb proto.Message, multimessage bool) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return ProtoBufToJSON(r, pb, multimessage)
+	})
+}
+
+// MsgPackToJSONWriter returns an io.WriteCloser that converts MsgPack
+// messages written to it into JSON messages, and writes the result to w.
+// See MsgPackToJSON.
+func MsgPackToJSONWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return MsgPackToJSON(r)
+	})
+}
+
+// JSONToMsgPackWriter returns an io.WriteCloser that converts JSON messages
+// written to it into MsgPack messages, and writes the result to w.
+// See JSONToMsgPack.
+func JSONToMsgPackWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToMsgPack(r)
+	})
+}
+
+// GzipperWriter will gzip everything written to it and write the compressed
+// data to w. Close must be called to flush the gzip footer.
+func GzipperWriter(w io.Writer) *transform.WriteTransformer {
+	zw := gzip.NewWriter(w)
+	return transform.NewWriteTransformer(w, func(p []byte, eof bool) ([]byte, error) {
+		if eof {
+			return nil, zw.Close()
+		}
+		_, err := zw.Write(p)
+		return nil, err
+	})
+}
+
+// GunzipperWriter will gunzip everything written to it and write the
+// uncompressed data to w.
+func GunzipperWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return Gunzipper(r)
 	})
 }
@@ -3,12 +3,21 @@ package transutil_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tidwall/transform"
 	"github.com/tidwall/transform/transutil"
 	"github.com/tidwall/transform/transutil/pbtest"
 )
@@ -49,6 +58,34 @@ func TestGzip(t *testing.T) {
 	if len(b) != 0 {
 		t.Fatal("not zero")
 	}
+	if err := unzipper.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGunzipperClose(t *testing.T) {
+	zipped, err := ioutil.ReadAll(transutil.Gzipper(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "transutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(zipped); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	r := transutil.JSONToUglyJSON(transutil.Gunzipper(f))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil {
+		t.Fatal("expected file to be closed")
+	}
 }
 
 func cleanJSON(a string) string {
@@ -140,3 +177,363 @@ func TestJSONToProtoAndBackMulti(t *testing.T) {
 		t.Fatal("not matching")
 	}
 }
+
+func TestWriters(t *testing.T) {
+	json := `{"name":{"first":"Jane","last":"Prichard"},"age":46}`
+	var out bytes.Buffer
+	w := transutil.JSONToMsgPackWriter(transutil.GzipperWriter(
+		transutil.GunzipperWriter(transutil.MsgPackToJSONWriter(&out))))
+	if _, err := io.WriteString(w, json); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !matchingJSON(json, out.String()) {
+		t.Fatal("json mismatch")
+	}
+}
+
+func TestPipelineStageErrors(t *testing.T) {
+	var pb pbtest.Test
+	pb2json := func(r io.Reader) *transform.Transformer {
+		return transutil.ProtoBufToJSON(r, &pb, true)
+	}
+	// bad gzip header
+	r := transform.NewPipeline(bytes.NewBufferString("not gzipped")).
+		Then("gunzip", transutil.Gunzipper).
+		Then("pb2json", pb2json).
+		Transformer()
+	_, err := ioutil.ReadAll(r)
+	var serr *transform.StageError
+	if !errors.As(err, &serr) || serr.Stage != "gunzip" {
+		t.Fatalf("expected gunzip stage error, got '%v'", err)
+	}
+	// bad varint
+	zipped, err := ioutil.ReadAll(transutil.Gzipper(bytes.NewBuffer([]byte{0xff, 0xff})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = transform.NewPipeline(bytes.NewBuffer(zipped)).
+		Then("gunzip", transutil.Gunzipper).
+		Then("pb2json", pb2json).
+		Transformer()
+	_, err = ioutil.ReadAll(r)
+	if !errors.As(err, &serr) || serr.Stage != "pb2json" || serr.Offset != 0 {
+		t.Fatalf("expected pb2json stage error, got '%v'", err)
+	}
+	// bad record in the middle of newline-delimited JSON
+	r = transform.NewPipeline(bytes.NewBufferString("{\"a\":1}\n{\"a\":}\n{\"a\":3}\n{\"a\":4}\n")).
+		Then("json2msgpack", transutil.JSONToMsgPack).
+		Transformer()
+	_, err = ioutil.ReadAll(r)
+	if !errors.As(err, &serr) || serr.Stage != "json2msgpack" || serr.Index != 1 || serr.Offset != 8 {
+		t.Fatalf("expected json2msgpack stage error at offset 8, got '%v'", err)
+	}
+}
+
+func TestProtoBufSplitVarint(t *testing.T) {
+	var pb pbtest.Test
+	var json string
+	json += `{"label":"hello","type":17,"reps":["1","2","3","4"],"optionalgroup":{"requiredField":"good bye"}}`
+	json += `{"label":"hola","type":17,"reps":["5","6","7","8"],"optionalgroup":{"requiredField":"adios"}}`
+	r := transform.NewSplitter(transutil.JSONToProtoBuf(bytes.NewBufferString(json), &pb, true), transform.SplitVarint)
+	var labels []string
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m pbtest.Test
+		if err := proto.Unmarshal(msg, &m); err != nil {
+			t.Fatal(err)
+		}
+		labels = append(labels, m.GetLabel())
+	}
+	if len(labels) != 2 || labels[0] != "hello" || labels[1] != "hola" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+}
+
+func testResume(t *testing.T, input []byte, stage func(r io.Reader, cp transform.Checkpoint) *transform.Transformer) {
+	expect, err := ioutil.ReadAll(stage(bytes.NewReader(input), transform.Checkpoint{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n <= len(expect); n += 7 {
+		r := stage(bytes.NewReader(input), transform.Checkpoint{})
+		head := make([]byte, n)
+		if _, err := io.ReadFull(r, head); err != nil {
+			t.Fatal(err)
+		}
+		cp, err := r.Checkpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = transform.Resume(bytes.NewReader(input), cp, stage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tail, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(append(head, tail...), expect) {
+			t.Fatalf("%d: mismatch", n)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	var ndjson string
+	for i := 0; i < 20; i++ {
+		ndjson += fmt.Sprintf(`{"label":"hello %d","type":17,"reps":["%d"]}`+"\n", i, i)
+	}
+	testResume(t, []byte(ndjson), func(r io.Reader, cp transform.Checkpoint) *transform.Transformer {
+		return transutil.JSONToUglyJSON(r)
+	})
+	testResume(t, []byte(ndjson), func(r io.Reader, cp transform.Checkpoint) *transform.Transformer {
+		return transutil.JSONToPrettyJSON(r)
+	})
+	var pb pbtest.Test
+	pbs, err := ioutil.ReadAll(transutil.JSONToProtoBuf(bytes.NewBufferString(ndjson), &pb, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testResume(t, pbs, func(r io.Reader, cp transform.Checkpoint) *transform.Transformer {
+		return transutil.ProtoBufToJSON(r, &pb, true)
+	})
+}
+
+func TestSkipErrors(t *testing.T) {
+	input := "{\"a\":1}\n{\"b\":x}\n{\"c\":3}\n  [1,2,}\n{\"d\":4}\n"
+	expect := `{"a":1}{"c":3}{"d":4}`
+
+	// the default policy stops at the first malformed record.
+	out, err := ioutil.ReadAll(transutil.JSONToUglyJSON(bytes.NewBufferString(input)))
+	var merr *transform.MessageError
+	if !errors.As(err, &merr) || string(merr.Raw) != `{"b":x}` {
+		t.Fatalf("expected '%v', got '%v'\n", `{"b":x}`, err)
+	}
+	if string(out) != `{"a":1}` {
+		t.Fatalf("expected '%v', got '%v'\n", `{"a":1}`, string(out))
+	}
+	// the decoder's error is still reachable.
+	var serr *json.SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected '%v', got '%T'\n", "*json.SyntaxError", merr.Err)
+	}
+	_, err = ioutil.ReadAll(transutil.JSONToMsgPack(bytes.NewBufferString("{\"a\":1}\n[1,2,}\n")))
+	if !errors.As(err, &serr) {
+		t.Fatalf("expected '%v', got '%v'\n", "*json.SyntaxError", err)
+	}
+
+	var count int
+	r := transutil.JSONToUglyJSON(bytes.NewBufferString(input)).
+		SkipErrors(func(raw []byte, err error) { count++ })
+	if out := string(mustReadAll(t, r)); out != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, out)
+	}
+	if count != 2 {
+		t.Fatalf("expected '%v', got '%v'\n", 2, count)
+	}
+
+	// a malformed value that spans lines is skipped as a whole.
+	var skipped []string
+	r = transutil.JSONToUglyJSON(bytes.NewBufferString("{\n\"a\": x,\n\"b\": [\n2\n]\n}\n{\"c\":3}\n")).
+		SkipErrors(func(raw []byte, err error) { skipped = append(skipped, string(raw)) })
+	if out := string(mustReadAll(t, r)); out != `{"c":3}` {
+		t.Fatalf("expected '%v', got '%v'\n", `{"c":3}`, out)
+	}
+	if len(skipped) != 1 || skipped[0] != "{\n\"a\": x,\n\"b\": [\n2\n]\n}" {
+		t.Fatalf("unexpected skipped values %q", skipped)
+	}
+
+	var dead bytes.Buffer
+	var pb pbtest.Test
+	r = transutil.JSONToProtoBuf(bytes.NewBufferString(
+		`{"label":"hello"}`+"\n"+`{"label":17}`+"\n"+`{"label":"hola"`+"\n"+`{"label":"hi"}`+"\n"),
+		&pb, true).DeadLetter(&dead, nil)
+	r = transutil.ProtoBufToJSON(r, &pb, true)
+	if out := string(mustReadAll(t, r)); out != `{"label":"hello"}{"label":"hi"}` {
+		t.Fatalf("expected '%v', got '%v'\n", `{"label":"hello"}{"label":"hi"}`, out)
+	}
+	if dead.String() != `{"label":17}`+"\n"+`{"label":"hola"`+"\n" {
+		t.Fatalf("expected '%v', got '%v'\n", `{"label":17}`+"\n"+`{"label":"hola"`+"\n", dead.String())
+	}
+}
+
+func TestSkipErrorsUpstream(t *testing.T) {
+	// the upstream error is returned, rather than skipped over and over.
+	var calls int
+	r := transutil.JSONToMsgPack(transutil.JSONToUglyJSON(bytes.NewBufferString("{\"a\":1}\n{\"b\":x}\n"))).
+		SkipErrors(func(raw []byte, err error) { calls++ })
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(r)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		var merr *transform.MessageError
+		if !errors.As(err, &merr) || string(merr.Raw) != `{"b":x}` {
+			t.Fatalf("expected '%v', got '%v'\n", `{"b":x}`, err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("chained converters did not finish")
+	}
+	if calls != 0 {
+		t.Fatalf("expected '%v', got '%v'\n", 0, calls)
+	}
+}
+
+func TestMsgPackNonStringKeys(t *testing.T) {
+	data, err := msgpack.Marshal([]interface{}{map[interface{}]interface{}{1: "one"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(transutil.MsgPackToJSON(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `[{"1":"one"}]` {
+		t.Fatalf("expected '%v', got '%v'\n", `[{"1":"one"}]`, string(out))
+	}
+	// keys of any type, in maps that are nested in maps and arrays.
+	data, err = msgpack.Marshal(map[interface{}]interface{}{
+		true: []interface{}{map[interface{}]interface{}{1.5: "x"}},
+		"s":  map[interface{}]interface{}{int64(-2): nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadAll(transutil.MsgPackToJSON(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := `{"s":{"-2":null},"true":[{"1.5":"x"}]}`; string(out) != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, string(out))
+	}
+}
+
+func mustReadAll(t *testing.T, r io.Reader) []byte {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReset(t *testing.T) {
+	var pb pbtest.Test
+	inputs := []string{
+		`{"label":"hello","type":17}` + "\n" + `{"label":"hola","reps":["1"]}` + "\n",
+		`{"label":"bonjour","type":3}` + "\n",
+	}
+	// encode returns the input in the format that each converter expects.
+	encode := func(fn func(io.Reader) *transform.Transformer, input string) []byte {
+		return mustReadAll(t, fn(bytes.NewBufferString(input)))
+	}
+	toProtoBuf := func(r io.Reader) *transform.Transformer {
+		return transutil.JSONToProtoBuf(r, &pb, true)
+	}
+	// decodeMsgPack decodes a stream of MsgPack messages, because their map
+	// keys are encoded in random order.
+	decodeMsgPack := func(data []byte) interface{} {
+		var vals []interface{}
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		for {
+			var v interface{}
+			if err := dec.Decode(&v); err == io.EOF {
+				return vals
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			vals = append(vals, v)
+		}
+	}
+	tests := []struct {
+		fn     func(r io.Reader) *transform.Transformer
+		input  func(string) []byte
+		decode func([]byte) interface{} // for comparing the output, if needed
+	}{
+		{transutil.JSONToPrettyJSON, nil, nil},
+		{transutil.JSONToUglyJSON, nil, nil},
+		{transutil.JSONToMsgPack, nil, decodeMsgPack},
+		{toProtoBuf, nil, nil},
+		{func(r io.Reader) *transform.Transformer {
+			return transutil.JSONToProtoBuf(r, &pb, false)
+		}, func(s string) []byte { return []byte(s[strings.LastIndex(s[:len(s)-1], "\n")+1:]) }, nil},
+		{func(r io.Reader) *transform.Transformer {
+			return transutil.ProtoBufToJSON(r, &pb, true)
+		}, func(s string) []byte { return encode(toProtoBuf, s) }, nil},
+		{func(r io.Reader) *transform.Transformer {
+			return transutil.ProtoBufToJSON(r, &pb, false)
+		}, func(s string) []byte {
+			return encode(func(r io.Reader) *transform.Transformer {
+				return transutil.JSONToProtoBuf(r, &pb, false)
+			}, s[strings.LastIndex(s[:len(s)-1], "\n")+1:])
+		}, nil},
+		{transutil.MsgPackToJSON, func(s string) []byte { return encode(transutil.JSONToMsgPack, s) }, nil},
+		{transutil.Gzipper, nil, nil},
+		{transutil.Gunzipper, func(s string) []byte { return encode(transutil.Gzipper, s) }, nil},
+	}
+	for i, tt := range tests {
+		if tt.input == nil {
+			tt.input = func(s string) []byte { return []byte(s) }
+		}
+		r := tt.fn(bytes.NewReader(tt.input(inputs[0])))
+		mustReadAll(t, r)
+		for _, input := range inputs {
+			if err := r.Reset(bytes.NewReader(tt.input(input))); err != nil {
+				t.Fatal(err)
+			}
+			expect := mustReadAll(t, tt.fn(bytes.NewReader(tt.input(input))))
+			out := mustReadAll(t, r)
+			if tt.decode != nil {
+				if !reflect.DeepEqual(tt.decode(out), tt.decode(expect)) {
+					t.Fatalf("%d: expected '%v', got '%v'\n", i, tt.decode(expect), tt.decode(out))
+				}
+			} else if !bytes.Equal(out, expect) {
+				t.Fatalf("%d: expected '%v', got '%v'\n", i, string(expect), string(out))
+			}
+		}
+	}
+}
+
+func benchmarkChain(b *testing.B, reset bool) {
+	var ndjson []byte
+	for i := 0; i < 100; i++ {
+		ndjson = append(ndjson, fmt.Sprintf(`{"label":"hello %d","type":17,"reps":["%d"]}`+"\n", i, i)...)
+	}
+	var pb pbtest.Test
+	src := bytes.NewReader(ndjson)
+	jp := transutil.JSONToProtoBuf(src, &pb, true)
+	gz := transutil.Gzipper(jp)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src.Reset(ndjson)
+		if reset {
+			jp.Reset(src)
+			gz.Reset(jp)
+		} else {
+			jp = transutil.JSONToProtoBuf(src, &pb, true)
+			gz = transutil.Gzipper(jp)
+		}
+		if _, err := io.Copy(ioutil.Discard, gz); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkChain(b *testing.B) {
+	benchmarkChain(b, false)
+}
+
+func BenchmarkChainReset(b *testing.B) {
+	benchmarkChain(b, true)
+}
@@ -0,0 +1,63 @@
+//go:build go1.18
+// +build go1.18
+
+package transutil
+
+import (
+	"encoding/json"
+	"io"
+
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+
+	"github.com/tidwall/transform"
+)
+
+// JSONSource returns a stream of decoded JSON values. Use it along with one
+// of the sinks, such as MsgPackSink, to convert between formats without
+// serializing the values in between.
+func JSONSource(r io.Reader) *transform.Source[interface{}] {
+	dec := json.NewDecoder(r)
+	return transform.NewSource(func() (interface{}, error) {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}).Upstream(r)
+}
+
+// MsgPackSource returns a stream of decoded MsgPack values. All map keys are
+// converted to strings, making the values suitable for any of the sinks.
+func MsgPackSource(r io.Reader) *transform.Source[interface{}] {
+	dec := msgpack.NewDecoder(r)
+	return transform.NewSource(func() (interface{}, error) {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		return remapKeysToStrings(v), nil
+	}).Upstream(r)
+}
+
+// JSONSink returns an io.Reader that encodes values as JSON messages
+// with all unneeded whitespace removed.
+func JSONSink(src *transform.Source[interface{}]) *transform.Transformer {
+	return transform.Sink[interface{}](func(v interface{}) ([]byte, error) {
+		return json.Marshal(&v)
+	}).Transformer(src)
+}
+
+// PrettyJSONSink returns an io.Reader that encodes values as human readable
+// JSON messages.
+func PrettyJSONSink(src *transform.Source[interface{}]) *transform.Transformer {
+	return transform.Sink[interface{}](func(v interface{}) ([]byte, error) {
+		return json.MarshalIndent(&v, "", "  ")
+	}).Transformer(src)
+}
+
+// MsgPackSink returns an io.Reader that encodes values as MsgPack messages.
+func MsgPackSink(src *transform.Source[interface{}]) *transform.Transformer {
+	return transform.Sink[interface{}](func(v interface{}) ([]byte, error) {
+		return msgpack.Marshal(&v)
+	}).Transformer(src)
+}
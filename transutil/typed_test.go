@@ -0,0 +1,25 @@
+//go:build go1.18
+// +build go1.18
+
+package transutil_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/tidwall/transform/transutil"
+)
+
+func TestTypedJSONToMsgPackAndBack(t *testing.T) {
+	json := `{"name":{"first":"Jane","last":"Prichard"},"age":46,"friends":["Charlie", "Vihaan", "Carol"]}`
+	r := transutil.PrettyJSONSink(transutil.MsgPackSource(
+		transutil.MsgPackSink(transutil.JSONSource(bytes.NewBufferString(json)))))
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matchingJSON(json, string(data)) {
+		t.Fatal("json mismatch")
+	}
+}
@@ -0,0 +1,361 @@
+package transutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/transform"
+)
+
+// The XML converters use the following mapping between XML and JSON.
+//
+//   - An element is a key of its parent object, and the names of elements
+//     and attributes keep their namespace prefix, such as "soap:Body".
+//   - Attributes are keys that are prefixed with "@", including namespace
+//     declarations, such as "@xmlns:soap".
+//   - An element without attributes or child elements is a string of its
+//     text. Otherwise it's an object, where the text, if it's not all
+//     whitespace, is the "#text" key.
+//   - Repeated elements are an array of their values.
+//
+// For example:
+//
+//   <book id="7"><title>Go</title><tag>a</tag><tag>b</tag></book>
+//
+// is converted into:
+//
+//   {"book":{"@id":"7","title":"Go","tag":["a","b"]}}
+//
+// The order of mixed text and elements, comments, and processing
+// instructions are not preserved. When converting back to XML, numbers and
+// booleans are written as text, and null as an empty element.
+
+// xmlObject is an element that preserves the order of its keys.
+type xmlObject struct {
+	keys []string
+	vals map[string]interface{} // string, *xmlObject, or []interface{}
+}
+
+func (o *xmlObject) add(key string, val interface{}) {
+	if o.vals == nil {
+		o.vals = make(map[string]interface{})
+	}
+	prev, ok := o.vals[key]
+	if !ok {
+		o.keys = append(o.keys, key)
+		o.vals[key] = val
+	} else if arr, ok := prev.([]interface{}); ok {
+		o.vals[key] = append(arr, val)
+	} else {
+		o.vals[key] = []interface{}{prev, val}
+	}
+}
+
+// MarshalJSON conforms to the json.Marshaler interface.
+func (o *xmlObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, key := range o.keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, key)
+		buf = append(buf, ':')
+		data, err := json.Marshal(o.vals[key])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+	}
+	return append(buf, '}'), nil
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// XMLToJSON returns an io.Reader that converts XML into JSON messages, using
+// the mapping described above. The document is read one token at a time.
+//
+// The element param selects the elements that are converted, such as the
+// repeating records of a huge document, and each one is emitted as a message
+// as soon as it ends. Everything outside of the selected elements is
+// skipped. An empty element param converts the entire document into a
+// single message.
+func XMLToJSON(r io.Reader, element string) *transform.Transformer {
+	dec := xml.NewDecoder(r)
+	var done bool // the root element was converted, when element is empty
+	return transform.NewTransformer(func() ([]byte, error) {
+		for {
+			tok, err := dec.RawToken()
+			if err != nil {
+				if err == io.EOF && element == "" && !done && dec.InputOffset() > 0 {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			start, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			name := xmlName(start.Name)
+			if (element != "" && name != element) || done {
+				continue
+			}
+			val, err := decodeXMLElement(dec, start)
+			if err != nil {
+				return nil, err
+			}
+			done = element == ""
+			var o xmlObject
+			o.add(name, val)
+			return json.Marshal(&o)
+		}
+	}).Upstream(r).OnReset(func(src io.Reader) error {
+		dec, done = xml.NewDecoder(src), false
+		return nil
+	})
+}
+
+// decodeXMLElement returns the value of an element, following its start
+// token.
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	var o xmlObject
+	for _, attr := range start.Attr {
+		o.add("@"+xmlName(attr.Name), attr.Value)
+	}
+	var text []byte
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			val, err := decodeXMLElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+			o.add(xmlName(tok.Name), val)
+		case xml.CharData:
+			text = append(text, tok...)
+		case xml.EndElement:
+			if tok.Name != start.Name {
+				return nil, errors.New("xml: element <" + xmlName(start.Name) +
+					"> closed by </" + xmlName(tok.Name) + ">")
+			}
+			if len(o.keys) == 0 {
+				return string(text), nil
+			}
+			if len(bytes.TrimSpace(text)) > 0 {
+				o.add("#text", string(text))
+			}
+			return &o, nil
+		}
+	}
+}
+
+// JSONToXML returns an io.Reader that converts JSON messages into XML, using
+// the mapping described above. Each message is an object whose keys are the
+// elements that it's converted into. A key that isn't a valid XML name
+// results in a *transform.MessageError, and the message is left out.
+//
+// The root param, when not empty, is the name of an element that encloses
+// all of the messages, which is needed for a well-formed document when
+// there's more than one message.
+func JSONToXML(r io.Reader, root string) *transform.Transformer {
+	jr := newJSONReader(r)
+	var b bytes.Buffer
+	var renc *xml.Encoder // encoder of the root element
+	var started, ended bool
+	return transform.NewTransformer(func() ([]byte, error) {
+		b.Reset()
+		if root != "" && !started {
+			if !isXMLName(root) {
+				return nil, errors.New("xml: invalid root element name " + strconv.Quote(root))
+			}
+			started = true
+			renc = xml.NewEncoder(&b)
+			if err := renc.EncodeToken(xml.StartElement{Name: xml.Name{Local: root}}); err != nil {
+				return nil, err
+			}
+			err := renc.Flush()
+			return b.Bytes(), err
+		}
+		raw, err := jr.nextRaw()
+		if err != nil {
+			if err == io.EOF && started && !ended {
+				ended = true
+				if err := renc.EncodeToken(xml.EndElement{Name: xml.Name{Local: root}}); err != nil {
+					return nil, err
+				}
+				err := renc.Flush()
+				return b.Bytes(), err
+			}
+			return nil, err
+		}
+		pairs, err := jsonObject(raw)
+		if err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		// each message has its own encoder, so that a message that fails
+		// halfway doesn't leave any open elements behind.
+		enc := xml.NewEncoder(&b)
+		for _, p := range pairs {
+			if err := encodeXMLElement(enc, p.key, p.val); err != nil {
+				return nil, &transform.MessageError{Raw: raw, Err: err}
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(func(src io.Reader) error {
+		started, ended = false, false
+		return jr.reset(src)
+	})
+}
+
+type jsonPair struct {
+	key string
+	val json.RawMessage
+}
+
+// jsonObject returns the members of a JSON object, in order.
+func jsonObject(raw json.RawMessage) ([]jsonPair, error) {
+	if firstByte(raw) != '{' {
+		return nil, errors.New("not an object")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.Token()
+	var pairs []jsonPair
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, jsonPair{tok.(string), val})
+	}
+	return pairs, nil
+}
+
+// encodeXMLElement writes a JSON value as an element.
+func encodeXMLElement(enc *xml.Encoder, name string, val json.RawMessage) error {
+	if !isXMLName(name) {
+		return errors.New("xml: invalid element name " + strconv.Quote(name))
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch firstByte(val) {
+	case '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(val, &elems); err != nil {
+			return err
+		}
+		for _, elem := range elems {
+			if err := encodeXMLElement(enc, name, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	case '{':
+		pairs, err := jsonObject(val)
+		if err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			if strings.HasPrefix(p.key, "@") {
+				if !isXMLName(p.key[1:]) {
+					return errors.New("xml: invalid attribute name " + strconv.Quote(p.key[1:]))
+				}
+				start.Attr = append(start.Attr, xml.Attr{
+					Name:  xml.Name{Local: p.key[1:]},
+					Value: csvValue(p.val),
+				})
+			}
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			switch {
+			case p.key == "#text":
+				err = enc.EncodeToken(xml.CharData(csvValue(p.val)))
+			case !strings.HasPrefix(p.key, "@"):
+				err = encodeXMLElement(enc, p.key, p.val)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if text := csvValue(val); text != "" {
+			if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLName reports whether s is a valid XML name, as defined by the Name
+// production of the XML 1.0 specification.
+func isXMLName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !isXMLNameStartChar(c) && (i == 0 || !isXMLNameChar(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isXMLNameStartChar(c rune) bool {
+	return c == ':' || c == '_' ||
+		(c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') ||
+		(c >= 0xC0 && c <= 0xD6) || (c >= 0xD8 && c <= 0xF6) ||
+		(c >= 0xF8 && c <= 0x2FF) || (c >= 0x370 && c <= 0x37D) ||
+		(c >= 0x37F && c <= 0x1FFF) || (c >= 0x200C && c <= 0x200D) ||
+		(c >= 0x2070 && c <= 0x218F) || (c >= 0x2C00 && c <= 0x2FEF) ||
+		(c >= 0x3001 && c <= 0xD7FF) || (c >= 0xF900 && c <= 0xFDCF) ||
+		(c >= 0xFDF0 && c <= 0xFFFD) || (c >= 0x10000 && c <= 0xEFFFF)
+}
+
+func isXMLNameChar(c rune) bool {
+	return c == '-' || c == '.' || (c >= '0' && c <= '9') || c == 0xB7 ||
+		(c >= 0x300 && c <= 0x36F) || (c >= 0x203F && c <= 0x2040)
+}
+
+// XMLToJSONWriter returns an io.WriteCloser that converts XML written to it
+// into JSON messages, and writes the result to w. See XMLToJSON.
+func XMLToJSONWriter(w io.Writer, element string) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return XMLToJSON(r, element)
+	})
+}
+
+// JSONToXMLWriter returns an io.WriteCloser that converts JSON messages
+// written to it into XML, and writes the result to w. See JSONToXML.
+func JSONToXMLWriter(w io.Writer, root string) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToXML(r, root)
+	})
+}
@@ -0,0 +1,115 @@
+package transutil_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/transform"
+	"github.com/tidwall/transform/transutil"
+)
+
+func TestXMLToJSON(t *testing.T) {
+	input := `<?xml version="1.0"?>
+<!-- a catalog -->
+<catalog xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <book id="7" lang="en">
+    <dc:title>Go &amp; you</dc:title>
+    <tag>a</tag>
+    <tag>b</tag>
+    <empty/>
+  </book>
+  <book id="8"><dc:title>Second</dc:title>text <b>bold</b></book>
+</catalog>
+`
+	msgs := readMessageStrings(t, transutil.XMLToJSON(strings.NewReader(input), "book"))
+	expect := []string{
+		`{"book":{"@id":"7","@lang":"en","dc:title":"Go \u0026 you","tag":["a","b"],"empty":""}}`,
+		`{"book":{"@id":"8","dc:title":"Second","b":"bold","#text":"text "}}`,
+	}
+	if strings.Join(msgs, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("expected '%v', got '%v'\n", expect, msgs)
+	}
+	msgs = readMessageStrings(t, transutil.XMLToJSON(strings.NewReader(input), ""))
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0],
+		`{"catalog":{"@xmlns:dc":"http://purl.org/dc/elements/1.1/","book":[{"@id":"7"`) {
+		t.Fatalf("unexpected output %v", msgs)
+	}
+
+	// malformed documents fail.
+	for _, input := range []string{"<a><b></a>", "<a><b>", "<a>"} {
+		r := transutil.XMLToJSON(strings.NewReader(input), "")
+		if _, err := r.ReadMessage(); err == nil {
+			t.Fatalf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestJSONToXMLAndBack(t *testing.T) {
+	input := `<catalog xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<book id="7"><dc:title>Go &amp; you</dc:title><tag>a</tag><tag>b</tag><empty></empty></book>` +
+		`<book id="8"><dc:title>Second</dc:title></book>` +
+		`</catalog>`
+	var out bytes.Buffer
+	w := transutil.XMLToJSONWriter(transutil.JSONToXMLWriter(&out, ""), "")
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != input {
+		t.Fatalf("expected '%v', got '%v'\n", input, out.String())
+	}
+
+	// selected elements are enclosed by a new root.
+	r := transutil.JSONToXML(transutil.XMLToJSON(strings.NewReader(input), "book"), "books")
+	expect := `<books><book id="7"><dc:title>Go &amp; you</dc:title><tag>a</tag><tag>b</tag><empty></empty></book>` +
+		`<book id="8"><dc:title>Second</dc:title></book></books>`
+	if out := string(mustReadAll(t, r)); out != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, out)
+	}
+
+	// other values.
+	r = transutil.JSONToXML(strings.NewReader(`{"a":{"@n":1,"#text":true,"b":null,"c":[1,"x"]}}`), "")
+	expect = `<a n="1">true<b></b><c>1</c><c>x</c></a>`
+	if out := string(mustReadAll(t, r)); out != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, out)
+	}
+}
+
+func TestJSONToXMLInvalidNames(t *testing.T) {
+	for _, input := range []string{
+		`{"first name":1}`, `{"1st":1}`, `{"a":{"@b c":1}}`, `{"a":{"#b":1}}`, `{"":1}`,
+	} {
+		_, err := ioutil.ReadAll(transutil.JSONToXML(strings.NewReader(input), ""))
+		var merr *transform.MessageError
+		if !errors.As(err, &merr) || string(merr.Raw) != input {
+			t.Fatalf("expected a message error for '%v', got '%v'\n", input, err)
+		}
+	}
+	// valid names, including non-ASCII ones.
+	input := `{"ns:a-b.c_d":{"@x:y":1,"été":2,"_":3}}`
+	expect := `<ns:a-b.c_d x:y="1"><été>2</été><_>3</_></ns:a-b.c_d>`
+	if out := string(mustReadAll(t, transutil.JSONToXML(strings.NewReader(input), ""))); out != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, out)
+	}
+	// a message that fails halfway is left out entirely.
+	input = `{"a":1}` + "\n" + `{"b":{"c":2,"d e":3}}` + "\n" + `{"f":4}` + "\n"
+	var skipped []string
+	r := transutil.JSONToXML(strings.NewReader(input), "root").
+		SkipErrors(func(raw []byte, err error) { skipped = append(skipped, string(raw)) })
+	expect = `<root><a>1</a><f>4</f></root>`
+	if out := string(mustReadAll(t, r)); out != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, out)
+	}
+	if len(skipped) != 1 || skipped[0] != `{"b":{"c":2,"d e":3}}` {
+		t.Fatalf("expected '%v', got '%v'\n", `{"b":{"c":2,"d e":3}}`, skipped)
+	}
+	// the root name is checked too.
+	if _, err := ioutil.ReadAll(transutil.JSONToXML(strings.NewReader(`{"a":1}`), "my root")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,114 @@
+package transutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/tidwall/transform"
+)
+
+// YAMLToJSON returns an io.Reader that converts YAML documents into JSON
+// messages, one message per document of a multi-document stream. Anchors
+// and aliases are resolved, and map keys that aren't strings, such as
+// numbers, are converted to strings.
+//
+// A document that has no JSON equivalent, such as one containing .inf,
+// results in a *transform.MessageError.
+func YAMLToJSON(r io.Reader) *transform.Transformer {
+	dec := yaml.NewDecoder(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		v = remapKeysToStrings(v)
+		data, err := json.Marshal(&v)
+		if err != nil {
+			raw, _ := yaml.Marshal(&v)
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return data, nil
+	}).Upstream(r).OnReset(func(src io.Reader) error {
+		dec = yaml.NewDecoder(src)
+		return nil
+	})
+}
+
+// JSONToYAML returns an io.Reader that converts JSON messages into YAML
+// documents. Each document starts with a "---" separator, making the output
+// a multi-document stream. Integers are written exactly, no matter their
+// size, and all other numbers as floats.
+//
+// The returned transformer supports checkpoints, see transform.Resume.
+func JSONToYAML(r io.Reader) *transform.Transformer {
+	jr := newJSONReader(r)
+	return transform.NewTransformer(func() ([]byte, error) {
+		raw, err := jr.nextRaw()
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		v = jsonToYAML(v)
+		data, err := yaml.Marshal(&v)
+		if err != nil {
+			return nil, &transform.MessageError{Raw: raw, Err: err}
+		}
+		return append([]byte("---\n"), data...), nil
+	}).Upstream(r).OnCheckpoint(jr.checkpoint).OnReset(jr.reset)
+}
+
+// jsonToYAML converts the numbers of a decoded JSON value into integers and
+// floats. Integers that don't fit into 64 bits are written as is, as plain
+// scalars without a tag, since most YAML decoders can't hold them.
+func jsonToYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = jsonToYAML(val)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonToYAML(v[i])
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return n
+		}
+		if !bytes.ContainsAny([]byte(v), ".eE") {
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: string(v)}
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// YAMLToJSONWriter returns an io.WriteCloser that converts YAML documents
+// written to it into JSON messages, and writes the result to w.
+// See YAMLToJSON.
+func YAMLToJSONWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return YAMLToJSON(r)
+	})
+}
+
+// JSONToYAMLWriter returns an io.WriteCloser that converts JSON messages
+// written to it into YAML documents, and writes the result to w.
+// See JSONToYAML.
+func JSONToYAMLWriter(w io.Writer) *transform.WriteTransformer {
+	return transform.NewReaderWriteTransformer(w, func(r io.Reader) io.Reader {
+		return JSONToYAML(r)
+	})
+}
@@ -0,0 +1,86 @@
+package transutil_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/transform"
+	"github.com/tidwall/transform/transutil"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	input := `
+defaults: &defaults
+  retries: 3
+  hosts: [a, b]
+service:
+  <<: *defaults
+  name: api
+ports:
+  80: http
+  443: https
+---
+- just
+- a list
+---
+plain
+`
+	msgs := readMessageStrings(t, transutil.YAMLToJSON(strings.NewReader(input)))
+	expect := []string{
+		`{"defaults":{"hosts":["a","b"],"retries":3},"ports":{"443":"https","80":"http"},` +
+			`"service":{"hosts":["a","b"],"name":"api","retries":3}}`,
+		`["just","a list"]`,
+		`"plain"`,
+	}
+	if strings.Join(msgs, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("expected '%v', got '%v'\n", expect, msgs)
+	}
+
+	// documents without a JSON equivalent can be skipped.
+	r := transutil.YAMLToJSON(strings.NewReader("a: 1\n---\nb: .inf\n---\nc: 3\n"))
+	_, err := r.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.ReadMessage()
+	var merr *transform.MessageError
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected '%v', got '%v'\n", "*transform.MessageError", err)
+	}
+	r = transutil.YAMLToJSON(strings.NewReader("a: 1\n---\nb: .inf\n---\nc: 3\n")).SkipErrors(nil)
+	if out := string(mustReadAll(t, r)); out != `{"a":1}{"c":3}` {
+		t.Fatalf("expected '%v', got '%v'\n", `{"a":1}{"c":3}`, out)
+	}
+}
+
+func TestJSONToYAMLAndBack(t *testing.T) {
+	input := `{"name":{"first":"Jane","last":"Prichard"},"age":46}
+[1,"two",null]
+`
+	yml := string(mustReadAll(t, transutil.JSONToYAML(strings.NewReader(input))))
+	expect := "---\nage: 46\nname:\n    first: Jane\n    last: Prichard\n---\n- 1\n- two\n- null\n"
+	if yml != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, yml)
+	}
+	var out bytes.Buffer
+	w := transutil.JSONToYAMLWriter(transutil.YAMLToJSONWriter(&out))
+	if _, err := w.Write([]byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != `{"age":46,"name":{"first":"Jane","last":"Prichard"}}[1,"two",null]` {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+
+	// integers are exact.
+	input = `{"i":9007199254740993,"u":18446744073709551615,"b":-123456789012345678901234567890,"f":1.5}`
+	yml = string(mustReadAll(t, transutil.JSONToYAML(strings.NewReader(input))))
+	expect = "---\nb: -123456789012345678901234567890\nf: 1.5\ni: 9007199254740993\nu: 18446744073709551615\n"
+	if yml != expect {
+		t.Fatalf("expected '%v', got '%v'\n", expect, yml)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build go1.18
+// +build go1.18
+
+package transform
+
+import "io"
+
+// Source represents a stream of decoded values. It allows for passing
+// values between stages without serializing them in between.
+type Source[T any] struct {
+	fn  func() (T, error) // user-defined decode function
+	src io.Reader         // upstream reader, if any
+	err error             // last error
+}
+
+// NewSource returns a stream of values. The param is a function that returns
+// the next value, or an error such as io.EOF once the stream is exhausted.
+func NewSource[T any](fn func() (T, error)) *Source[T] {
+	return &Source[T]{fn: fn}
+}
+
+// SourceOf returns a stream of values that are decoded from the messages of
+// a transformer. The decode param is called once for each message.
+func SourceOf[T any](r *Transformer, decode func(msg []byte) (T, error)) *Source[T] {
+	s := NewSource(func() (T, error) {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			var v T
+			return v, err
+		}
+		return decode(msg)
+	})
+	s.src = r
+	return s
+}
+
+// Upstream registers the reader that the source decodes from. It's passed
+// along to the transformer returned by a Sink, which allows for Close and
+// BindContext to reach the rest of the chain. Returns the source.
+func (s *Source[T]) Upstream(src io.Reader) *Source[T] {
+	s.src = src
+	return s
+}
+
+// Next returns the next value. Once an error is returned, all following
+// calls return the same error.
+func (s *Source[T]) Next() (T, error) {
+	var v T
+	if s.err != nil {
+		return v, s.err
+	}
+	v, s.err = s.fn()
+	return v, s.err
+}
+
+// Stage represents a conversion from one value to another.
+type Stage[In, Out any] func(v In) (Out, error)
+
+// Apply returns a stream of values that are converted by a stage.
+func Apply[In, Out any](src *Source[In], stage Stage[In, Out]) *Source[Out] {
+	return NewSource(func() (Out, error) {
+		v, err := src.Next()
+		if err != nil {
+			var out Out
+			return out, err
+		}
+		return stage(v)
+	}).Upstream(src.src)
+}
+
+// Sink represents an encoder that turns values into messages.
+type Sink[T any] func(v T) ([]byte, error)
+
+// Transformer returns a transformer that encodes each value in the stream
+// into a message.
+func (encode Sink[T]) Transformer(src *Source[T]) *Transformer {
+	return NewTransformer(func() ([]byte, error) {
+		v, err := src.Next()
+		if err != nil {
+			return nil, err
+		}
+		return encode(v)
+	}).Upstream(src.src)
+}
@@ -0,0 +1,64 @@
+//go:build go1.18
+// +build go1.18
+
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+func TestTypedPipeline(t *testing.T) {
+	lines := NewTransformer(func() func() ([]byte, error) {
+		var i int
+		return func() ([]byte, error) {
+			if i == 5 {
+				return nil, io.EOF
+			}
+			i++
+			return []byte(strconv.Itoa(i)), nil
+		}
+	}())
+	nums := SourceOf(lines, func(msg []byte) (int, error) {
+		return strconv.Atoi(string(msg))
+	})
+	squares := Apply(nums, Stage[int, int](func(v int) (int, error) {
+		return v * v, nil
+	}))
+	r := Sink[int](func(v int) ([]byte, error) {
+		return []byte(strconv.Itoa(v) + "\n"), nil
+	}).Transformer(squares)
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "1\n4\n9\n16\n25\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "1\n4\n9\n16\n25\n", string(out))
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lines.ReadMessage(); err != ErrClosed {
+		t.Fatalf("expected '%v', got '%v'\n", ErrClosed, err)
+	}
+}
+
+func TestTypedError(t *testing.T) {
+	errBad := errors.New("bad")
+	src := SourceOf(Rot13(bytes.NewBufferString("hello")), func(msg []byte) (string, error) {
+		return "", errBad
+	})
+	r := Sink[string](func(v string) ([]byte, error) {
+		return []byte(v), nil
+	}).Transformer(src)
+	if _, err := ioutil.ReadAll(r); err != errBad {
+		t.Fatalf("expected '%v', got '%v'\n", errBad, err)
+	}
+	if _, err := src.Next(); err != errBad {
+		t.Fatalf("expected '%v', got '%v'\n", errBad, err)
+	}
+}
@@ -0,0 +1,97 @@
+package transform
+
+import "io"
+
+// WriteTransformer represents a transform writer. It's the push-based
+// counterpart to Transformer.
+type WriteTransformer struct {
+	w      io.Writer                                // downstream writer
+	tfn    func(p []byte, eof bool) ([]byte, error) // user-defined transform function
+	err    error                                    // last error
+	closed bool                                     // transformer has been closed
+}
+
+// NewWriteTransformer returns an object that can be used for transforming
+// one data format to another while writing to w.
+//
+// The fn param is a function that performs the conversion. It receives each
+// incoming chunk and returns the transformed data, if any, which is then
+// written to w. Once the transformer is closed the function is called one
+// last time with eof set to true. This allows for flushing any data that the
+// function may still be holding on to.
+func NewWriteTransformer(w io.Writer, fn func(p []byte, eof bool) ([]byte, error)) *WriteTransformer {
+	return &WriteTransformer{w: w, tfn: fn}
+}
+
+// NewReaderWriteTransformer returns a WriteTransformer that pushes the
+// written data through a pull-based transformer, such as one created with
+// NewTransformer. The fn param is the transformer constructor, and it'll be
+// called once with a reader that yields all data written to the returned
+// transformer.
+//
+// The conversion runs in a background goroutine, which exits once the
+// transformer is closed.
+func NewReaderWriteTransformer(w io.Writer, fn func(r io.Reader) io.Reader) *WriteTransformer {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(w, fn(pr))
+		// Unblock any pending writes in case the transformer stopped reading
+		// before the input was fully consumed.
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	return NewWriteTransformer(w, func(p []byte, eof bool) ([]byte, error) {
+		if !eof {
+			_, err := pw.Write(p)
+			return nil, err
+		}
+		pw.Close()
+		return nil, <-done
+	})
+}
+
+// Write conforms to io.Writer
+func (w *WriteTransformer) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := w.write(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close flushes any remaining data to the downstream writer. When the
+// downstream writer is itself a WriteTransformer it's closed too, allowing
+// for an entire chain to be flushed with a single call. Any other
+// downstream writer is left open.
+func (w *WriteTransformer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err == nil {
+		w.write(nil, true)
+	}
+	if next, ok := w.w.(*WriteTransformer); ok {
+		if err := next.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
+
+func (w *WriteTransformer) write(p []byte, eof bool) error {
+	var msg []byte
+	msg, w.err = w.tfn(p, eof)
+	if len(msg) > 0 {
+		if _, err := w.w.Write(msg); err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
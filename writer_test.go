@@ -0,0 +1,71 @@
+package transform
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// LineUpper buffers incoming data and writes complete lines in upper case.
+func LineUpper(w io.Writer) *WriteTransformer {
+	var line []byte
+	return NewWriteTransformer(w, func(p []byte, eof bool) ([]byte, error) {
+		line = append(line, p...)
+		i := bytes.LastIndexByte(line, '\n')
+		if eof {
+			i = len(line) - 1
+		}
+		if i < 0 {
+			return nil, nil
+		}
+		out := bytes.ToUpper(line[:i+1])
+		line = append(line[:0], line[i+1:]...)
+		return out, nil
+	})
+}
+
+func TestWriteTransformer(t *testing.T) {
+	var buf bytes.Buffer
+	w := LineUpper(&buf)
+	if _, err := io.WriteString(w, "hello\nwor"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "HELLO\n" {
+		t.Fatalf("expected '%v', got '%v'\n", "HELLO\n", buf.String())
+	}
+	if _, err := io.WriteString(w, "ld"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "HELLO\nWORLD" {
+		t.Fatalf("expected '%v', got '%v'\n", "HELLO\nWORLD", buf.String())
+	}
+	if _, err := w.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Fatalf("expected '%v', got '%v'\n", io.ErrClosedPipe, err)
+	}
+}
+
+func TestWriteTransformerChain(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewReaderWriteTransformer(LineUpper(&buf), func(r io.Reader) io.Reader {
+		return Rot13(r)
+	})
+	msg := strings.Repeat("Hello\n13th Floor", 100)
+	if _, err := io.WriteString(w, msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(Rot13(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != strings.ToUpper(msg) {
+		t.Fatalf("expected '%v', got '%v'\n", strings.ToUpper(msg), string(data))
+	}
+}